@@ -4,7 +4,9 @@ go 1.21.5
 
 require (
 	github.com/caddyserver/caddy/v2 v2.7.6
+	github.com/docker/docker v24.0.7+incompatible
 	github.com/docker/go-connections v0.4.0
+	github.com/klauspost/compress v1.17.0
 	github.com/librespeed/speedtest-cli v1.0.10
 	github.com/point-c/caddy v0.1.0
 	github.com/point-c/simplewg v0.1.0
@@ -45,7 +47,6 @@ require (
 	github.com/dgryski/go-farm v0.0.0-20200201041132-a6ae2369ad13 // indirect
 	github.com/dlclark/regexp2 v1.10.0 // indirect
 	github.com/docker/distribution v2.8.2+incompatible // indirect
-	github.com/docker/docker v24.0.7+incompatible // indirect
 	github.com/docker/go-units v0.5.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/emirpasic/gods v1.12.0 // indirect
@@ -91,7 +92,6 @@ require (
 	github.com/johnstarich/go/gopages v0.1.25 // indirect
 	github.com/johnstarich/go/pipe v0.2.0 // indirect
 	github.com/kevinburke/ssh_config v0.0.0-20190725054713-01f96b0aa0cd // indirect
-	github.com/klauspost/compress v1.17.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.5 // indirect
 	github.com/libdns/libdns v0.2.1 // indirect
 	github.com/lufia/plan9stats v0.0.0-20211012122336-39d0f177ccd0 // indirect
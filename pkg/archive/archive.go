@@ -19,14 +19,23 @@ type (
 		io.Closer
 		WriteFile(errs.Testing, FileHeader, io.Reader)
 		WriteDir(errs.Testing, FileHeader)
+		WriteLink(errs.Testing, FileHeader)
 	}
 )
 
 // Archive is responsible for the actual archiving.
 func Archive[A Archiver](t errs.Testing, w io.Writer, files ...FileHeader) {
-	ww := (*new(A)).New(t, w)
-	defer errs.Defer(t, ww.Close)
-	readDir(t, ww, nil, files)
+	ArchiveWith(t, *new(A), w, files...)
+}
+
+// ArchiveWith archives using a configured archiver, such as a [TarGz] with a compression level.
+func ArchiveWith(t errs.Testing, a Archiver, w io.Writer, files ...FileHeader) {
+	write(t, a.New(t, w), files)
+}
+
+func write(t errs.Testing, w Writer, files []FileHeader) {
+	defer errs.Defer(t, w.Close)
+	readDir(t, w, nil, files)
 }
 
 func readDir(t errs.Testing, w Writer, path []string, files []FileHeader) {
@@ -34,12 +43,21 @@ func readDir(t errs.Testing, w Writer, path []string, files []FileHeader) {
 		fn := Entry[[]byte]{
 			Name: strings.Join(append(path, f.EntryName()), "/"),
 			Time: f.EntryTime(),
+			Mode: f.EntryMode(),
+			Link: f.EntryLink(),
+		}
+		fn.Uid, fn.Gid = f.EntryOwner()
+		if fn.Link != "" {
+			w.WriteLink(t, fn)
+			continue
 		}
 		switch f := f.(type) {
 		case entry[[]byte]:
 			w.WriteFile(t, fn, bytes.NewReader(f.EntryContent()))
 		case entry[io.Reader]:
 			w.WriteFile(t, fn, f.EntryContent())
+		case entry[SizedReader]:
+			w.WriteFile(t, fn, f.EntryContent())
 		case entry[[]FileHeader]:
 			w.WriteDir(t, fn)
 			readDir(t, w, append(path, f.EntryName()), f.EntryContent())
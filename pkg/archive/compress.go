@@ -0,0 +1,60 @@
+package archive
+
+import (
+	"compress/gzip"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/point-c/integration/pkg/errs"
+	"io"
+)
+
+type (
+	// TarGz allows writing gzip compressed .tar.gz archives.
+	TarGz struct {
+		// Level is the gzip compression level. [gzip.DefaultCompression] is used if it is zero.
+		Level int
+	}
+	// TarZstd allows writing zstd compressed .tar.zst archives.
+	TarZstd struct {
+		// Level is the zstd compression level, from 1 to 22 like the zstd command. The default level is used if it is zero.
+		Level int
+	}
+	// compressedWriter writes a tar archive through a compressor.
+	compressedWriter struct {
+		Writer
+		c io.Closer
+	}
+)
+
+func (a TarGz) New(t errs.Testing, w io.Writer) Writer {
+	level := a.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	gz := errs.Must(gzip.NewWriterLevel(w, level))(t)
+	return &compressedWriter{Writer: Tar{}.New(t, gz), c: gz}
+}
+
+func (TarGz) Read(t errs.Testing, r io.Reader) []FileHeader {
+	gz := errs.Must(gzip.NewReader(r))(t)
+	defer errs.Defer(t, gz.Close)
+	return Tar{}.Read(t, gz)
+}
+
+func (a TarZstd) New(t errs.Testing, w io.Writer) Writer {
+	var opts []zstd.EOption
+	if a.Level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(a.Level)))
+	}
+	zw := errs.Must(zstd.NewWriter(w, opts...))(t)
+	return &compressedWriter{Writer: Tar{}.New(t, zw), c: zw}
+}
+
+func (TarZstd) Read(t errs.Testing, r io.Reader) []FileHeader {
+	zr := errs.Must(zstd.NewReader(r))(t)
+	defer zr.Close()
+	return Tar{}.Read(t, zr)
+}
+
+// Close closes the archive, then flushes the compressor.
+func (w *compressedWriter) Close() error { return errors.Join(w.Writer.Close(), w.c.Close()) }
@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/point-c/integration/pkg/errs"
+	"hash"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Epoch is the time of every entry in a deterministic archive. It is the earliest time zip archives can hold.
+var Epoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type (
+	// Manifest describes an archive written by [Deterministic].
+	Manifest struct {
+		// Digest is the hex encoded SHA-256 of the archive.
+		Digest string `json:"digest"`
+		// Files are the entries of the archive, in the order they were written.
+		Files []ManifestFile `json:"files"`
+	}
+	// ManifestFile is an entry in a [Manifest].
+	ManifestFile struct {
+		// Name is the slash separated path of the entry.
+		Name string      `json:"name"`
+		Mode fs.FileMode `json:"mode"`
+		// Size and Digest describe the content of files. Digest is the hex encoded SHA-256 of the content.
+		Size   int64  `json:"size,omitempty"`
+		Digest string `json:"digest,omitempty"`
+		Link   string `json:"link,omitempty"`
+	}
+)
+
+// Deterministic archives the files so the same content always produces the same archive, no matter when or by whom it is written.
+// Every entry gets the time [Epoch] and no owner, entries are sorted by name, and modes are normalized:
+// folders and executable files are 0755, other files are 0644 and symlinks are 0777.
+func Deterministic(t errs.Testing, a Archiver, w io.Writer, files ...FileHeader) Manifest {
+	h := sha256.New()
+	mw := &manifestWriter{Writer: a.New(t, io.MultiWriter(w, h))}
+	write(t, mw, normalize(files))
+	return Manifest{Digest: hex.EncodeToString(h.Sum(nil)), Files: mw.files}
+}
+
+func normalize(files []FileHeader) []FileHeader {
+	files = slices.Clone(files)
+	slices.SortStableFunc(files, func(a, b FileHeader) int { return strings.Compare(a.EntryName(), b.EntryName()) })
+	n := make([]FileHeader, 0, len(files))
+	for _, f := range files {
+		if f.EntryLink() != "" {
+			n = append(n, Entry[[]byte]{Name: f.EntryName(), Time: Epoch, Mode: fs.ModePerm, Link: f.EntryLink()})
+			continue
+		}
+		mode := fs.FileMode(0o644)
+		if f.EntryMode()&0o111 != 0 {
+			mode = 0o755
+		}
+		switch f := f.(type) {
+		case entry[[]byte]:
+			n = append(n, Entry[[]byte]{Name: f.EntryName(), Time: Epoch, Mode: mode, Content: f.EntryContent()})
+		case entry[io.Reader]:
+			n = append(n, Entry[io.Reader]{Name: f.EntryName(), Time: Epoch, Mode: mode, Content: f.EntryContent()})
+		case entry[SizedReader]:
+			n = append(n, Entry[SizedReader]{Name: f.EntryName(), Time: Epoch, Mode: mode, Content: f.EntryContent()})
+		case entry[[]FileHeader]:
+			n = append(n, Entry[[]FileHeader]{Name: f.EntryName(), Time: Epoch, Mode: 0o755, Content: normalize(f.EntryContent())})
+		}
+	}
+	return n
+}
+
+// manifestWriter records every entry written to the archive.
+type manifestWriter struct {
+	Writer
+	files []ManifestFile
+}
+
+func (w *manifestWriter) WriteFile(t errs.Testing, f FileHeader, r io.Reader) {
+	d := digester{Hash: sha256.New()}
+	// Keep the size known, so tar archives can still stream the content
+	switch sr := r.(type) {
+	case SizedReader:
+		r = SizedReader{Reader: io.TeeReader(sr.Reader, &d), Size: sr.Size}
+	case interface{ Len() int }:
+		r = SizedReader{Reader: io.TeeReader(r, &d), Size: int64(sr.Len())}
+	default:
+		r = io.TeeReader(r, &d)
+	}
+	w.Writer.WriteFile(t, f, r)
+	w.files = append(w.files, ManifestFile{Name: f.EntryName(), Mode: f.EntryMode(), Size: d.n, Digest: hex.EncodeToString(d.Sum(nil))})
+}
+
+func (w *manifestWriter) WriteDir(t errs.Testing, f FileHeader) {
+	w.Writer.WriteDir(t, f)
+	w.files = append(w.files, ManifestFile{Name: f.EntryName(), Mode: f.EntryMode() | fs.ModeDir})
+}
+
+func (w *manifestWriter) WriteLink(t errs.Testing, f FileHeader) {
+	w.Writer.WriteLink(t, f)
+	w.files = append(w.files, ManifestFile{Name: f.EntryName(), Mode: f.EntryMode() | fs.ModeSymlink, Link: f.EntryLink()})
+}
+
+// digester hashes and counts everything written to it.
+type digester struct {
+	hash.Hash
+	n int64
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.Hash.Write(p)
+}
@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeterministic(t *testing.T) {
+	tree := func(tm time.Time, uid int, mode fs.FileMode, reverse bool) []FileHeader {
+		files := []FileHeader{
+			Entry[[]byte]{Name: "a.txt", Time: tm, Uid: uid, Mode: mode, Content: []byte("a")},
+			Entry[[]FileHeader]{Name: "dir", Time: tm, Mode: mode, Content: []FileHeader{
+				Entry[io.Reader]{Name: "b.sh", Time: tm, Mode: mode | 0o100, Content: strings.NewReader("bb")},
+				Entry[[]byte]{Name: "link", Time: tm, Link: "b.sh"},
+			}},
+		}
+		if reverse {
+			files[0], files[1] = files[1], files[0]
+		}
+		return files
+	}
+	for _, a := range []Archiver{Tar{}, Zip{}, TarGz{}, TarZstd{}} {
+		var b1, b2 bytes.Buffer
+		m1 := Deterministic(t, a, &b1, tree(time.Now(), 0, 0o600, false)...)
+		m2 := Deterministic(t, a, &b2, tree(time.Now().Add(-time.Hour), 1000, 0o664, true)...)
+		require.Equal(t, b1.Bytes(), b2.Bytes(), "%T", a)
+		require.Equal(t, m1, m2, "%T", a)
+		sum := sha256.Sum256(b1.Bytes())
+		require.Equal(t, hex.EncodeToString(sum[:]), m1.Digest, "%T", a)
+	}
+
+	var buf bytes.Buffer
+	m := Deterministic(t, Tar{}, &buf, tree(time.Now(), 0, 0o640, false)...)
+	digest := func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) }
+	require.Equal(t, []ManifestFile{
+		{Name: "a.txt", Mode: 0o644, Size: 1, Digest: digest("a")},
+		{Name: "dir", Mode: 0o755 | fs.ModeDir},
+		{Name: "dir/b.sh", Mode: 0o755, Size: 2, Digest: digest("bb")},
+		{Name: "dir/link", Mode: 0o777 | fs.ModeSymlink, Link: "b.sh"},
+	}, m.Files)
+}
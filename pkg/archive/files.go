@@ -1,6 +1,10 @@
 package archive
 
-import "time"
+import (
+	"io"
+	"io/fs"
+	"time"
+)
 
 type (
 	// FileHeader is an interface for all files in the archive.
@@ -8,21 +12,47 @@ type (
 		entry()
 		EntryName() string
 		EntryTime() time.Time
+		EntryMode() fs.FileMode
+		EntryOwner() (uid, gid int)
+		EntryLink() string
 	}
 	entry[C any] interface {
 		FileHeader
 		EntryContent() C
 	}
-	// Entry is a file or folder in the archive. Files are defined by `Entry[[]byte]` or `Entry[io.Reader]`.
-	// Folders are defined by `Entry[[]FileHeader]`.
+	// Entry is a file or folder in the archive. Files are defined by `Entry[[]byte]`, `Entry[io.Reader]` or `Entry[SizedReader]`.
+	// Folders are defined by `Entry[[]FileHeader]`. Any entry with Link set is a symlink, its content is ignored.
 	Entry[C any] struct {
-		Name    string
-		Time    time.Time
+		Name string
+		Time time.Time
+		// Mode holds the permission bits of the file or folder. 0777 is used if it is zero.
+		Mode fs.FileMode
+		// Uid and Gid are the numeric owner of the file. Zip archives store them in an Info-ZIP unix extra field.
+		Uid, Gid int
+		// Link is the target of a symlink.
+		Link    string
 		Content C
 	}
 )
 
+// SizedReader is file content with a size known ahead of time. Tar archives stream it without buffering.
+// Content from a plain io.Reader has to be buffered to a temporary file first, since tar headers contain the size.
+type SizedReader struct {
+	io.Reader
+	Size int64
+}
+
 func (Entry[C]) entry()                   {}
 func (bce Entry[C]) EntryName() string    { return bce.Name }
 func (bce Entry[C]) EntryTime() time.Time { return bce.Time }
 func (bce Entry[C]) EntryContent() C      { return bce.Content }
+func (bce Entry[C]) EntryLink() string    { return bce.Link }
+
+func (bce Entry[C]) EntryOwner() (uid, gid int) { return bce.Uid, bce.Gid }
+
+func (bce Entry[C]) EntryMode() fs.FileMode {
+	if bce.Mode.Perm() == 0 {
+		return bce.Mode | fs.ModePerm
+	}
+	return bce.Mode
+}
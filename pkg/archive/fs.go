@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/point-c/integration/pkg/errs"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+type (
+	// FSOption filters the files read by [FS].
+	FSOption  func(*fsOptions)
+	fsOptions struct {
+		include []string
+		exclude []string
+	}
+)
+
+// Include only reads files matching at least one of the patterns, along with the folders that contain them.
+// Patterns use [path.Match] syntax, `**` matches any number of folders. A pattern matching a folder matches everything in it.
+func Include(patterns ...string) FSOption {
+	return func(o *fsOptions) { o.include = append(o.include, patterns...) }
+}
+
+// Exclude skips files matching the patterns, using the same rules as a .dockerignore file.
+// Patterns are checked in order and the last one that matches wins. A pattern starting with `!` includes files again.
+// See [Include] for the pattern syntax.
+func Exclude(patterns ...string) FSOption {
+	return func(o *fsOptions) { o.exclude = append(o.exclude, patterns...) }
+}
+
+// IgnorePatterns parses a .dockerignore file into patterns for [Exclude]. Empty lines and comments are skipped.
+func IgnorePatterns(b []byte) (patterns []string) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if l := strings.TrimSpace(s.Text()); l != "" && !strings.HasPrefix(l, "#") {
+			patterns = append(patterns, l)
+		}
+	}
+	return
+}
+
+// Dir reads a directory on the host, see [FS].
+func Dir(t errs.Testing, dir string, opts ...FSOption) []FileHeader {
+	t.Helper()
+	return FS(t, os.DirFS(dir), opts...)
+}
+
+// FS reads every file in fsys into a tree of entries, keeping modification times and modes.
+// Symlinks and other irregular files are skipped with a log message.
+// Filters are matched against the slash separated path of each file from the root of fsys.
+func FS(t errs.Testing, fsys fs.FS, opts ...FSOption) []FileHeader {
+	t.Helper()
+	var o fsOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return readFS(t, fsys, ".", &o)
+}
+
+func readFS(t errs.Testing, fsys fs.FS, dir string, o *fsOptions) (files []FileHeader) {
+	for _, e := range errs.Must(fs.ReadDir(fsys, dir))(t) {
+		name := path.Join(dir, e.Name())
+		excluded := o.excluded(name)
+		info := errs.Must(e.Info())(t)
+		switch {
+		case e.IsDir():
+			// Excluded folders are only read if an exception could include something in them
+			if excluded && !o.exceptions() {
+				continue
+			}
+			content := readFS(t, fsys, name, o)
+			if len(content) == 0 && (excluded || !o.included(name)) {
+				continue
+			}
+			files = append(files, Entry[[]FileHeader]{Name: e.Name(), Time: info.ModTime(), Mode: info.Mode().Perm(), Content: content})
+		case e.Type().IsRegular() && !excluded && o.included(name):
+			files = append(files, Entry[[]byte]{
+				Name:    e.Name(),
+				Time:    info.ModTime(),
+				Mode:    info.Mode().Perm(),
+				Content: errs.Must(fs.ReadFile(fsys, name))(t),
+			})
+		case !excluded && o.included(name):
+			// Symlinks would be resolved differently inside the image, so they are left out
+			t.Logf("skipping %s, only regular files and folders are read", name)
+		}
+	}
+	return
+}
+
+func (o *fsOptions) included(name string) bool {
+	if len(o.include) == 0 {
+		return true
+	}
+	for _, p := range o.include {
+		if matchPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *fsOptions) excluded(name string) (excluded bool) {
+	for _, p := range o.exclude {
+		if negated := strings.HasPrefix(p, "!"); negated {
+			if matchPattern(p[1:], name) {
+				excluded = false
+			}
+		} else if matchPattern(p, name) {
+			excluded = true
+		}
+	}
+	return
+}
+
+func (o *fsOptions) exceptions() bool {
+	for _, p := range o.exclude {
+		if strings.HasPrefix(p, "!") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern reports if the pattern matches name or any of the folders containing it.
+func matchPattern(pattern, name string) bool {
+	pattern = strings.Trim(path.Clean("/"+pattern), "/")
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		if matchParts(strings.Split(pattern, "/"), parts[:i+1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFS(t *testing.T) {
+	tm := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"main.go":           {Data: []byte("main"), Mode: 0o644, ModTime: tm},
+		"run.sh":            {Data: []byte("run"), Mode: 0o755, ModTime: tm},
+		"pkg":               {Mode: fs.ModeDir | 0o750, ModTime: tm},
+		"pkg/a.go":          {Data: []byte("a"), Mode: 0o600, ModTime: tm},
+		"pkg/a_test.go":     {Data: []byte("a test"), Mode: 0o644, ModTime: tm},
+		".git/HEAD":         {Data: []byte("ref"), Mode: 0o644, ModTime: tm},
+		"vendor/x/x.go":     {Data: []byte("x"), Mode: 0o644, ModTime: tm},
+		"vendor/x/LICENSE":  {Data: []byte("license"), Mode: 0o644, ModTime: tm},
+		"docs/nested/a.md":  {Data: []byte("docs"), Mode: 0o644, ModTime: tm},
+		"docs/nested/b.txt": {Data: []byte("docs"), Mode: 0o644, ModTime: tm},
+	}
+	names := func(files []FileHeader) (n []string) {
+		var walk func(string, []FileHeader)
+		walk = func(p string, files []FileHeader) {
+			for _, f := range files {
+				switch f := f.(type) {
+				case Entry[[]FileHeader]:
+					walk(p+f.Name+"/", f.Content)
+				default:
+					n = append(n, p+f.EntryName())
+				}
+			}
+		}
+		walk("", files)
+		return
+	}
+
+	files := FS(t, fsys)
+	require.Contains(t, files, Entry[[]byte]{Name: "run.sh", Time: tm, Mode: 0o755, Content: []byte("run")})
+	require.Len(t, names(files), len(fsys)-1)
+
+	require.Equal(t, []string{"main.go", "pkg/a.go", "run.sh"},
+		names(FS(t, fsys, Exclude(IgnorePatterns([]byte("# comment\n.git\n**/*_test.go\nvendor\n\ndocs\n"))...))))
+	require.Equal(t, []string{"pkg/a.go", "vendor/x/x.go"},
+		names(FS(t, fsys, Include("**/*.go"), Exclude("main.go", "**/*_test.go"))))
+	require.Equal(t, []string{"vendor/x/LICENSE"},
+		names(FS(t, fsys, Include("vendor"), Exclude("vendor", "!vendor/*/LICENSE"))))
+	require.Equal(t, []string{"docs/nested/a.md"}, names(FS(t, fsys, Include("docs/**/*.md"))))
+
+	d := FS(t, fsys, Include("pkg"))[0].(Entry[[]FileHeader])
+	require.Equal(t, fs.FileMode(0o750), d.Mode, "folder modes must be kept")
+	require.Equal(t, tm, d.Time)
+}
@@ -0,0 +1,286 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/point-c/integration/pkg/errs"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Reader is implemented by an archive format that can be read.
+type Reader interface {
+	// Read reads the archive into a tree of `Entry[[]byte]` files and `Entry[[]FileHeader]` folders.
+	Read(errs.Testing, io.Reader) []FileHeader
+}
+
+// Read reads the archive in r.
+// Folders that are not in the archive but contain files are created. Times are in UTC. Archives with names outside the root fail.
+func Read[R Reader](t errs.Testing, r io.Reader) []FileHeader {
+	return (*new(R)).Read(t, r)
+}
+
+func (Tar) Read(t errs.Testing, r io.Reader) []FileHeader {
+	var root node
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		errs.Check(t, err)
+		e := node{time: hdr.ModTime.UTC(), mode: fs.FileMode(hdr.Mode).Perm(), uid: hdr.Uid, gid: hdr.Gid}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			e.dir = true
+			root.add(t, hdr.Name, e)
+		case tar.TypeReg:
+			e.content = errs.Must(io.ReadAll(tr))(t)
+			root.add(t, hdr.Name, e)
+		case tar.TypeSymlink:
+			e.link = hdr.Linkname
+			root.add(t, hdr.Name, e)
+		}
+	}
+	return root.headers()
+}
+
+func (Zip) Read(t errs.Testing, r io.Reader) []FileHeader {
+	b := errs.Must(io.ReadAll(r))(t)
+	zr := errs.Must(zip.NewReader(bytes.NewReader(b), int64(len(b))))(t)
+	var root node
+	for _, f := range zr.File {
+		e := node{time: f.Modified.UTC(), mode: f.Mode().Perm(), dir: f.FileInfo().IsDir()}
+		e.uid, e.gid = parseUnixExtra(f.Extra)
+		if !e.dir {
+			rc := errs.Must(f.Open())(t)
+			e.content = errs.Must(io.ReadAll(rc))(t)
+			errs.Check(t, rc.Close())
+		}
+		if f.Mode()&fs.ModeSymlink != 0 {
+			e.link, e.content = string(e.content), nil
+		}
+		root.add(t, f.Name, e)
+	}
+	return root.headers()
+}
+
+// node builds a tree of entries from the flat list of names in an archive.
+type node struct {
+	name     string
+	time     time.Time
+	mode     fs.FileMode
+	uid, gid int
+	link     string
+	dir      bool
+	content  []byte
+	children []*node
+}
+
+// add adds e to the tree at name. The name and children of e are ignored.
+func (n *node) add(t errs.Testing, name string, e node) {
+	name = path.Clean(strings.TrimSuffix(name, "/"))
+	if name == "." {
+		return
+	}
+	if !fs.ValidPath(name) {
+		errs.Check(t, fmt.Errorf("archive entry %q is outside the archive root", name))
+	}
+	parts := strings.Split(name, "/")
+	for _, p := range parts[:len(parts)-1] {
+		n = n.child(p, e.time)
+	}
+	c := n.child(parts[len(parts)-1], e.time)
+	e.name, e.children = c.name, c.children
+	*c = e
+}
+
+// child finds or creates the child folder called name.
+func (n *node) child(name string, tm time.Time) *node {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &node{name: name, time: tm, dir: true}
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *node) headers() []FileHeader {
+	h := make([]FileHeader, len(n.children))
+	for i, c := range n.children {
+		if c.dir {
+			h[i] = Entry[[]FileHeader]{Name: c.name, Time: c.time, Mode: c.mode, Uid: c.uid, Gid: c.gid, Content: c.headers()}
+		} else {
+			h[i] = Entry[[]byte]{Name: c.name, Time: c.time, Mode: c.mode, Uid: c.uid, Gid: c.gid, Link: c.link, Content: c.content}
+		}
+	}
+	return h
+}
+
+// Extract writes the files to dir, creating it if needed. Modification times and modes are kept, owners are not.
+// It fails without writing anything if any file would be written outside of dir, a name is in files more than once,
+// or any symlink points outside of dir or through another symlink in files. Files are never written through a symlink.
+// A [SizedReader] that is shorter or longer than its size fails.
+func Extract(t errs.Testing, dir string, files ...FileHeader) {
+	links := map[string]bool{}
+	seen := map[string]bool{}
+	errs.Check(t, walk(nil, files, func(p []string, f FileHeader) error {
+		name := filepath.Join(p...)
+		if seen[name] {
+			return fmt.Errorf("%q is in the archive more than once", filepath.ToSlash(name))
+		}
+		seen[name] = true
+		if f.EntryLink() != "" {
+			links[name] = true
+		}
+		return nil
+	}))
+	errs.Check(t, walk(nil, files, func(p []string, f FileHeader) error {
+		name := filepath.Join(p...)
+		for d := filepath.Dir(name); d != "."; d = filepath.Dir(d) {
+			if links[d] {
+				return fmt.Errorf("%q would be written through symlink %q", filepath.ToSlash(name), filepath.ToSlash(d))
+			}
+		}
+		if l := f.EntryLink(); l != "" && !localLink(links, name, filepath.FromSlash(l)) {
+			return fmt.Errorf("symlink %q points outside the extraction directory", filepath.ToSlash(name))
+		}
+		return nil
+	}))
+	errs.Check(t, os.MkdirAll(dir, 0o755))
+	type folder struct {
+		name string
+		f    FileHeader
+	}
+	var folders []folder
+	errs.Check(t, walk(nil, files, func(p []string, f FileHeader) error {
+		name := filepath.Join(append([]string{dir}, p...)...)
+		if err := noSymlinks(dir, filepath.Join(p...)); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return err
+		}
+		if l := f.EntryLink(); l != "" {
+			return os.Symlink(filepath.FromSlash(l), name)
+		}
+		var err error
+		switch f := f.(type) {
+		case entry[[]FileHeader]:
+			folders = append(folders, folder{name: name, f: f})
+			return os.MkdirAll(name, 0o755)
+		case entry[[]byte]:
+			_, err = writeFile(name, bytes.NewReader(f.EntryContent()))
+		case entry[io.Reader]:
+			_, err = writeFile(name, f.EntryContent())
+		case entry[SizedReader]:
+			// One more byte is read so readers longer than their size are noticed, like when archiving them
+			sr := f.EntryContent()
+			var n int64
+			if n, err = writeFile(name, io.LimitReader(sr, sr.Size+1)); err == nil && n != sr.Size {
+				err = fmt.Errorf("%q has %d bytes, expected %d", filepath.ToSlash(f.EntryName()), n, sr.Size)
+			}
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return chmodTimes(name, f)
+	}))
+	// Folders are updated last, since writing their content changes their time and they may not be writable
+	for i := len(folders) - 1; i >= 0; i-- {
+		errs.Check(t, chmodTimes(folders[i].name, folders[i].f))
+	}
+}
+
+// localLink reports if the symlink at name pointing to target stays inside the extraction directory.
+// The target may only end at another of links, it may not pass through one since its target is not resolved.
+func localLink(links map[string]bool, name, target string) bool {
+	if filepath.IsAbs(target) {
+		return false
+	}
+	var resolved []string
+	if d := filepath.Dir(name); d != "." {
+		resolved = strings.Split(d, string(filepath.Separator))
+	}
+	comps := slices.DeleteFunc(strings.Split(target, string(filepath.Separator)), func(c string) bool { return c == "" || c == "." })
+	for i, c := range comps {
+		if c != ".." {
+			resolved = append(resolved, c)
+			if i < len(comps)-1 && links[filepath.Join(resolved...)] {
+				return false
+			}
+		} else if len(resolved) == 0 {
+			return false
+		} else {
+			resolved = resolved[:len(resolved)-1]
+		}
+	}
+	return true
+}
+
+// noSymlinks fails if name or any of its parents inside dir is a symlink, so nothing is written through one.
+func noSymlinks(dir, name string) error {
+	cur := dir
+	for _, c := range strings.Split(name, string(filepath.Separator)) {
+		cur = filepath.Join(cur, c)
+		fi, err := os.Lstat(cur)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%q would be written through symlink %q", filepath.ToSlash(name), filepath.ToSlash(strings.TrimPrefix(cur, dir+string(filepath.Separator))))
+		}
+	}
+	return nil
+}
+
+func chmodTimes(name string, f FileHeader) error {
+	if err := os.Chmod(name, f.EntryMode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(name, f.EntryTime(), f.EntryTime())
+}
+
+// walk calls fn for every file, parents first, with the path of the file split into local components.
+func walk(parent []string, files []FileHeader, fn func([]string, FileHeader) error) error {
+	for _, f := range files {
+		name := filepath.FromSlash(f.EntryName())
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("%q is outside the extraction directory", path.Join(append(parent, f.EntryName())...))
+		}
+		p := append(append([]string(nil), parent...), name)
+		if err := fn(p, f); err != nil {
+			return err
+		}
+		if d, ok := f.(entry[[]FileHeader]); ok && f.EntryLink() == "" {
+			if err := walk(p, d.EntryContent(), fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeFile(name string, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	return n, errors.Join(err, f.Close())
+}
@@ -0,0 +1,179 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/point-c/integration/pkg/errs/errstest"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	tm := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []FileHeader{
+		Entry[[]byte]{Name: "a.txt", Time: tm, Mode: 0o644, Content: []byte("a")},
+		Entry[[]FileHeader]{Name: "dir", Time: tm, Mode: 0o755, Content: []FileHeader{
+			Entry[[]byte]{Name: "b.sh", Time: tm, Mode: 0o755, Uid: 1000, Gid: 100, Content: []byte("b")},
+			Entry[[]byte]{Name: "a.txt", Time: tm, Mode: 0o777, Link: "../a.txt"},
+			Entry[[]FileHeader]{Name: "empty", Time: tm, Mode: 0o700, Content: []FileHeader{}},
+		}},
+	}
+	for _, tt := range []struct {
+		Name string
+		Archiver
+		Reader
+	}{
+		{Name: "tar", Archiver: Tar{}, Reader: Tar{}},
+		{Name: "zip", Archiver: Zip{}, Reader: Zip{}},
+		{Name: "tar.gz", Archiver: TarGz{}, Reader: TarGz{}},
+		{Name: "tar.gz best", Archiver: TarGz{Level: gzip.BestCompression}, Reader: TarGz{}},
+		{Name: "tar.zst", Archiver: TarZstd{}, Reader: TarZstd{}},
+		{Name: "tar.zst 19", Archiver: TarZstd{Level: 19}, Reader: TarZstd{}},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ArchiveWith(t, tt.Archiver, &buf, files...)
+			require.Equal(t, files, tt.Read(t, &buf))
+		})
+	}
+}
+
+func TestReadTraversal(t *testing.T) {
+	var buf bytes.Buffer
+	Archive[Tar](t, &buf, Entry[[]byte]{Name: "../evil", Content: []byte("evil")})
+	r := errstest.NewRecorder(t)
+	require.True(t, r.Failed(func() { Read[Tar](r, &buf) }))
+}
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	tm := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	Extract(t, dir,
+		Entry[[]FileHeader]{Name: "dir", Time: tm, Content: []FileHeader{
+			Entry[[]byte]{Name: "a.txt", Time: tm, Mode: 0o600, Content: []byte("a")},
+			Entry[[]byte]{Name: "link.txt", Link: "a.txt"},
+		}},
+		Entry[*bytes.Reader]{Name: "ignored"},
+	)
+	b, err := os.ReadFile(filepath.Join(dir, "dir", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(b))
+	fi, err := os.Stat(filepath.Join(dir, "dir", "a.txt"))
+	require.NoError(t, err)
+	require.True(t, tm.Equal(fi.ModTime()))
+	require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	l, err := os.Readlink(filepath.Join(dir, "dir", "link.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "a.txt", l)
+
+	r := errstest.NewRecorder(t)
+	require.True(t, r.Failed(func() {
+		Extract(r, dir, Entry[[]FileHeader]{Name: "dir", Content: []FileHeader{
+			Entry[[]byte]{Name: "ok.txt"},
+			Entry[[]byte]{Name: "../../evil.txt"},
+		}})
+	}))
+	_, err = os.Stat(filepath.Join(dir, "dir", "ok.txt"))
+	require.ErrorIs(t, err, os.ErrNotExist, "nothing may be written if any file is outside the directory")
+
+	for _, l := range []string{"/etc/passwd", "../../evil.txt"} {
+		r := errstest.NewRecorder(t)
+		require.True(t, r.Failed(func() {
+			Extract(r, dir, Entry[[]FileHeader]{Name: "dir", Content: []FileHeader{Entry[[]byte]{Name: "evil", Link: l}}})
+		}), l)
+	}
+
+	Extract(t, dir, Entry[SizedReader]{Name: "sized", Content: SizedReader{Reader: io.LimitReader(zeros{}, 2), Size: 2}})
+	b, err = os.ReadFile(filepath.Join(dir, "sized"))
+	require.NoError(t, err)
+	require.Len(t, b, 2)
+	require.True(t, r.Failed(func() {
+		Extract(r, dir, Entry[SizedReader]{Name: "short", Content: SizedReader{Reader: io.LimitReader(zeros{}, 1), Size: 2}})
+	}), "extract must fail if the reader is shorter than its size")
+	require.True(t, r.Failed(func() {
+		Extract(r, dir, Entry[SizedReader]{Name: "long", Content: SizedReader{Reader: io.LimitReader(zeros{}, 2), Size: 1}})
+	}), "extract must fail if the reader is longer than its size")
+}
+
+func TestExtractSymlinkTraversal(t *testing.T) {
+	for name, files := range map[string][]FileHeader{
+		"chain": {
+			Entry[[]FileHeader]{Name: "a", Content: []FileHeader{Entry[[]byte]{Name: "b", Link: ".."}}},
+			Entry[[]FileHeader]{Name: "a", Content: []FileHeader{Entry[[]FileHeader]{Name: "b", Content: []FileHeader{Entry[[]byte]{Name: "c", Link: ".."}}}}},
+			Entry[[]FileHeader]{Name: "a", Content: []FileHeader{Entry[[]FileHeader]{Name: "b", Content: []FileHeader{Entry[[]FileHeader]{Name: "c", Content: []FileHeader{
+				Entry[[]byte]{Name: "pwned", Content: []byte("pwned")},
+			}}}}}},
+		},
+		"flat chain": {
+			Entry[[]byte]{Name: "a/b", Link: "."},
+			Entry[[]byte]{Name: "a/b/c", Link: ".."},
+			Entry[[]byte]{Name: "a/b/c/pwned", Content: []byte("pwned")},
+		},
+		"repeated name": {
+			Entry[[]FileHeader]{Name: "a", Content: []FileHeader{}},
+			Entry[[]byte]{Name: "a", Link: ".."},
+		},
+		"through link": {
+			Entry[[]byte]{Name: "a/b", Link: ".."},
+			Entry[[]byte]{Name: "c", Link: "a/b/.."},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "dir")
+			r := errstest.NewRecorder(t)
+			require.True(t, r.Failed(func() { Extract(r, dir, files...) }))
+			entries, err := os.ReadDir(root)
+			require.NoError(t, err)
+			require.Len(t, entries, 0, "nothing may be written")
+		})
+	}
+
+	t.Run("existing link", func(t *testing.T) {
+		root := t.TempDir()
+		dir := filepath.Join(root, "dir")
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.Symlink("..", filepath.Join(dir, "a")))
+		r := errstest.NewRecorder(t)
+		require.True(t, r.Failed(func() { Extract(r, dir, Entry[[]byte]{Name: "a/pwned", Content: []byte("pwned")}) }))
+		_, err := os.Stat(filepath.Join(root, "pwned"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	dir := t.TempDir()
+	Extract(t, dir, Entry[[]byte]{Name: "a", Content: []byte("a")}, Entry[[]byte]{Name: "b", Link: "a"}, Entry[[]byte]{Name: "c", Link: "b"})
+	b, err := os.ReadFile(filepath.Join(dir, "c"))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(b), "links may point to other links")
+}
+
+func TestTarStreaming(t *testing.T) {
+	const size = 8 * 1024 * 1024
+	var buf bytes.Buffer
+	Archive[Tar](t, &buf,
+		Entry[SizedReader]{Name: "sized", Content: SizedReader{Reader: io.LimitReader(zeros{}, size), Size: size}},
+		Entry[io.Reader]{Name: "unsized", Content: io.LimitReader(zeros{}, size)},
+	)
+	files := Read[Tar](t, &buf)
+	require.Len(t, files, 2)
+	for _, f := range files {
+		require.Len(t, f.(Entry[[]byte]).Content, size, f.EntryName())
+	}
+
+	r := errstest.NewRecorder(t)
+	require.True(t, r.Failed(func() {
+		Archive[Tar](r, io.Discard, Entry[SizedReader]{Name: "short", Content: SizedReader{Reader: io.LimitReader(zeros{}, 1), Size: 2}})
+	}), "archive must fail if the reader is shorter than its size")
+	require.True(t, r.Failed(func() {
+		Archive[Tar](r, io.Discard, Entry[SizedReader]{Name: "long", Content: SizedReader{Reader: io.LimitReader(zeros{}, 2), Size: 1}})
+	}), "archive must fail if the reader is longer than its size")
+}
+
+// zeros is an endless reader of zeros.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) { clear(p); return len(p), nil }
@@ -2,7 +2,6 @@ package archive
 
 import (
 	"archive/tar"
-	"bytes"
 	"github.com/point-c/integration/pkg/errs"
 	"io"
 	"os"
@@ -23,26 +22,59 @@ func (Tar) New(t errs.Testing, w io.Writer) Writer {
 func (w *tarWriter) Close() error { return w.w.Close() }
 
 func (w *tarWriter) WriteFile(t errs.Testing, f FileHeader, r io.Reader) {
-	b := bytes.NewReader(errs.Must(io.ReadAll(r))(t))
-	errs.Check(t, w.w.WriteHeader(&tar.Header{
-		Typeflag:   tar.TypeReg,
-		Name:       f.EntryName(),
-		Size:       int64(b.Len()),
-		Mode:       int64(os.ModePerm),
-		ModTime:    f.EntryTime(),
-		AccessTime: f.EntryTime(),
-		ChangeTime: f.EntryTime(),
-	}))
-	errs.Must(io.Copy(w.w, b))(t)
+	r, size, cleanup := sized(t, r)
+	defer cleanup()
+	hdr := tarHeader(f, tar.TypeReg)
+	hdr.Size = size
+	errs.Check(t, w.w.WriteHeader(hdr))
+	// Fails if r is longer than size, closing the archive fails if it is shorter
+	errs.Must(io.Copy(w.w, r))(t)
+}
+
+// sized finds the size of r. Readers of an unknown size are copied to a temporary file, which is removed by cleanup.
+func sized(t errs.Testing, r io.Reader) (_ io.Reader, size int64, cleanup func()) {
+	switch r := r.(type) {
+	case SizedReader:
+		return r.Reader, r.Size, func() {}
+	case interface{ Len() int }:
+		// bytes.Reader, bytes.Buffer and strings.Reader
+		return r.(io.Reader), int64(r.Len()), func() {}
+	}
+
+	f := errs.Must(os.CreateTemp("", "archive-*"))(t)
+	cleanup = func() { _ = f.Close(); _ = os.Remove(f.Name()) }
+	panicked := true
+	defer func() {
+		if panicked {
+			cleanup()
+		}
+	}()
+	size = errs.Must(io.Copy(f, r))(t)
+	errs.Must(f.Seek(0, io.SeekStart))(t)
+	panicked = false
+	return f, size, cleanup
 }
 
 func (w *tarWriter) WriteDir(t errs.Testing, f FileHeader) {
-	errs.Check(t, w.w.WriteHeader(&tar.Header{
-		Typeflag:   tar.TypeDir,
+	errs.Check(t, w.w.WriteHeader(tarHeader(f, tar.TypeDir)))
+}
+
+func (w *tarWriter) WriteLink(t errs.Testing, f FileHeader) {
+	hdr := tarHeader(f, tar.TypeSymlink)
+	hdr.Linkname = f.EntryLink()
+	errs.Check(t, w.w.WriteHeader(hdr))
+}
+
+func tarHeader(f FileHeader, typ byte) *tar.Header {
+	uid, gid := f.EntryOwner()
+	return &tar.Header{
+		Typeflag:   typ,
 		Name:       f.EntryName(),
-		Mode:       int64(os.ModePerm),
+		Mode:       int64(f.EntryMode().Perm()),
+		Uid:        uid,
+		Gid:        gid,
 		ModTime:    f.EntryTime(),
 		AccessTime: f.EntryTime(),
 		ChangeTime: f.EntryTime(),
-	}))
+	}
 }
@@ -2,10 +2,11 @@ package archive
 
 import (
 	"archive/zip"
+	"encoding/binary"
 	"github.com/point-c/integration/pkg/errs"
 	"io"
 	"io/fs"
-	"os"
+	"strings"
 )
 
 // Zip allows writing .zip archives.
@@ -23,15 +24,65 @@ func (Zip) New(t errs.Testing, w io.Writer) Writer {
 func (w *zipWriter) Close() error { return w.w.Close() }
 
 func (w *zipWriter) WriteFile(t errs.Testing, f FileHeader, r io.Reader) {
-	errs.Must(io.Copy(errs.Must(w.w.CreateHeader(zipHeader(f, zip.Deflate, os.ModePerm)))(t), r))(t)
+	errs.Must(io.Copy(errs.Must(w.w.CreateHeader(zipHeader(f, zip.Deflate, f.EntryMode().Perm())))(t), r))(t)
 }
 
 func (w *zipWriter) WriteDir(t errs.Testing, f FileHeader) {
-	errs.Must(w.w.CreateHeader(zipHeader(f, zip.Store, os.ModePerm|os.ModeDir)))(t)
+	errs.Must(w.w.CreateHeader(zipHeader(f, zip.Store, f.EntryMode().Perm()|fs.ModeDir)))(t)
+}
+
+// WriteLink stores the target as the content of the entry, the same way Info-ZIP does.
+func (w *zipWriter) WriteLink(t errs.Testing, f FileHeader) {
+	errs.Must(io.Copy(errs.Must(w.w.CreateHeader(zipHeader(f, zip.Store, f.EntryMode().Perm()|fs.ModeSymlink)))(t), strings.NewReader(f.EntryLink())))(t)
 }
 
 func zipHeader(f FileHeader, method uint16, mode fs.FileMode) *zip.FileHeader {
 	hdr := zip.FileHeader{Name: f.EntryName(), Modified: f.EntryTime(), Method: method}
 	hdr.SetMode(mode)
+	if uid, gid := f.EntryOwner(); uid != 0 || gid != 0 {
+		hdr.Extra = unixExtra(uid, gid)
+	}
 	return &hdr
 }
+
+// unixExtraID is the id of the Info-ZIP "new unix" extra field, which holds the owner of a file.
+const unixExtraID = 0x7875
+
+func unixExtra(uid, gid int) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, unixExtraID)
+	b = binary.LittleEndian.AppendUint16(b, 11)
+	b = append(b, 1, 4)
+	b = binary.LittleEndian.AppendUint32(b, uint32(uid))
+	b = append(b, 4)
+	return binary.LittleEndian.AppendUint32(b, uint32(gid))
+}
+
+// parseUnixExtra finds the owner in the extra fields of a zip entry.
+func parseUnixExtra(extra []byte) (uid, gid int) {
+	for len(extra) >= 4 {
+		id, size := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != unixExtraID || len(field) < 2 || field[0] != 1 {
+			continue
+		}
+		field = field[1:]
+		var ids [2]int
+		for i := range ids {
+			if len(field) < 1 || len(field) < 1+int(field[0]) {
+				return
+			}
+			n := int(field[0])
+			var v uint64
+			for j := n; j > 0; j-- {
+				v = v<<8 | uint64(field[j])
+			}
+			ids[i], field = int(v), field[1+n:]
+		}
+		return ids[0], ids[1]
+	}
+	return
+}
@@ -0,0 +1,334 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+	"github.com/testcontainers/testcontainers-go/network"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// Backend creates the networks and containers used by a [MainContext].
+	Backend interface {
+		// CreateNetwork creates a new network.
+		CreateNetwork(context.Context, ...network.NetworkCustomizer) (Network, error)
+		// RemoveNetwork removes a network made by CreateNetwork.
+		RemoveNetwork(context.Context, Network) error
+		// BuildImage builds the docker build context and tags the image with tag. Build output is written to log.
+		BuildImage(ctx context.Context, tag string, buildContext io.Reader, log io.Writer) error
+		// ImageExists reports if an image tagged with tag is available, so it does not need to be built.
+		ImageExists(ctx context.Context, tag string) (bool, error)
+		// RemoveImage removes the image tagged with tag. Images that do not exist or are still used by a container are kept without an error.
+		RemoveImage(ctx context.Context, tag string) error
+		// StartContainer creates and starts a container, returning once it is ready.
+		StartContainer(context.Context, testcontainers.ContainerRequest) (Container, error)
+		// FindContainer attaches to the container called req.Name, starting it if it is stopped. It returns nil if there is no such container.
+		// labels are the labels the container was created with.
+		FindContainer(ctx context.Context, req testcontainers.ContainerRequest) (c Container, labels map[string]string, err error)
+		// ConnectNetwork connects a running container to the network. Nothing is done if it is already connected.
+		ConnectNetwork(ctx context.Context, c Container, network string) error
+		// DisconnectNetwork disconnects a running container from the network.
+		DisconnectNetwork(ctx context.Context, c Container, network string) error
+		// StopContainer stops a container made by StartContainer.
+		StopContainer(context.Context, Container, *time.Duration) error
+		// ContainerLogs follows the output of a container made by StartContainer, starting at since.
+		// All output is streamed if since is zero. The stream ends when the container stops.
+		ContainerLogs(ctx context.Context, c Container, since time.Time) (io.ReadCloser, error)
+		// Exec runs a command in a container made by StartContainer, returning its exit code and output.
+		Exec(context.Context, Container, []string) (int, []byte, error)
+		// Pause freezes every process in a container made by StartContainer.
+		Pause(context.Context, Container) error
+		// Unpause resumes a container frozen by Pause.
+		Unpause(context.Context, Container) error
+		// Kill sends SIGKILL to a container made by StartContainer.
+		Kill(context.Context, Container) error
+		// Restart stops a container made by StartContainer if it is running, then starts it again.
+		// It does not wait for the container to be ready.
+		Restart(context.Context, Container, *time.Duration) error
+		// InspectContainer describes a container made by StartContainer, in the format of `docker inspect`.
+		InspectContainer(context.Context, Container) ([]byte, error)
+		// InspectNetwork describes a network made by CreateNetwork, in the format of `docker network inspect`.
+		InspectNetwork(context.Context, Network) ([]byte, error)
+	}
+	// Network is a network made by a [Backend].
+	Network struct {
+		ID   string
+		Name string
+	}
+	// Container is a running client, server, or helper container.
+	Container interface {
+		MappedPort(context.Context, nat.Port) (nat.Port, error)
+	}
+)
+
+// Testcontainers is a [Backend] that runs docker containers with testcontainers.
+type Testcontainers struct {
+	l        sync.Mutex
+	networks map[string]*testcontainers.DockerNetwork
+	client   *testcontainers.DockerClient
+}
+
+func (tc *Testcontainers) CreateNetwork(ctx context.Context, opts ...network.NetworkCustomizer) (Network, error) {
+	n, err := network.New(ctx, opts...)
+	if err != nil {
+		return Network{}, err
+	}
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	if tc.networks == nil {
+		tc.networks = map[string]*testcontainers.DockerNetwork{}
+	}
+	tc.networks[n.ID] = n
+	return Network{ID: n.ID, Name: n.Name}, nil
+}
+
+func (tc *Testcontainers) RemoveNetwork(ctx context.Context, n Network) error {
+	tc.l.Lock()
+	dn, ok := tc.networks[n.ID]
+	delete(tc.networks, n.ID)
+	tc.l.Unlock()
+	if !ok {
+		return fmt.Errorf("network %q was not created by this backend", n.Name)
+	}
+	return dn.Remove(ctx)
+}
+
+func (tc *Testcontainers) BuildImage(ctx context.Context, tag string, buildContext io.Reader, log io.Writer) error {
+	cli, err := tc.dockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := cli.ImageBuild(ctx, buildContext, types.ImageBuildOptions{Tags: []string{tag}, Remove: true, ForceRemove: true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Returns the build error if there is one
+	return jsonmessage.DisplayJSONMessagesStream(resp.Body, log, 0, false, nil)
+}
+
+func (tc *Testcontainers) ImageExists(ctx context.Context, tag string) (bool, error) {
+	cli, err := tc.dockerClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	if _, _, err := cli.ImageInspectWithRaw(ctx, tag); client.IsErrNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (tc *Testcontainers) RemoveImage(ctx context.Context, tag string) error {
+	cli, err := tc.dockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	// Another test may use the same image, it is removed by the last one
+	if _, err := cli.ImageRemove(ctx, tag, types.ImageRemoveOptions{}); err != nil && !client.IsErrNotFound(err) && !errdefs.IsConflict(err) {
+		return err
+	}
+	return nil
+}
+
+func (*Testcontainers) StartContainer(ctx context.Context, req testcontainers.ContainerRequest) (Container, error) {
+	return testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+}
+
+func (tc *Testcontainers) FindContainer(ctx context.Context, req testcontainers.ContainerRequest) (Container, map[string]string, error) {
+	cli, err := tc.dockerClient(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.Arg("name", req.Name))})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range containers {
+		if slices.Contains(c.Names, "/"+req.Name) {
+			dc, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{ContainerRequest: req, Started: true, Reuse: true})
+			return dc, c.Labels, err
+		}
+	}
+	return nil, nil, nil
+}
+
+func (tc *Testcontainers) ConnectNetwork(ctx context.Context, c Container, network string) error {
+	dc, err := dockerContainer(c)
+	if err != nil {
+		return err
+	}
+	networks, err := dc.Networks(ctx)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(networks, network) {
+		return nil
+	}
+	cli, err := tc.dockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	return cli.NetworkConnect(ctx, network, dc.GetContainerID(), nil)
+}
+
+func (tc *Testcontainers) DisconnectNetwork(ctx context.Context, c Container, network string) error {
+	dc, err := dockerContainer(c)
+	if err != nil {
+		return err
+	}
+	cli, err := tc.dockerClient(ctx)
+	if err != nil {
+		return err
+	}
+	return cli.NetworkDisconnect(ctx, network, dc.GetContainerID(), false)
+}
+
+func (*Testcontainers) StopContainer(ctx context.Context, c Container, timeout *time.Duration) error {
+	dc, err := dockerContainer(c)
+	if err != nil {
+		return err
+	}
+	return dc.Stop(ctx, timeout)
+}
+
+func (tc *Testcontainers) ContainerLogs(ctx context.Context, c Container, since time.Time) (io.ReadCloser, error) {
+	cli, id, err := tc.docker(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	opts := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true}
+	if !since.IsZero() {
+		opts.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+	rc, err := cli.ContainerLogs(ctx, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	// The stream multiplexes stdout and stderr
+	pr, pw := io.Pipe()
+	go func() {
+		defer rc.Close()
+		_, err := stdcopy.StdCopy(pw, pw, rc)
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+func (tc *Testcontainers) Pause(ctx context.Context, c Container) error {
+	cli, id, err := tc.docker(ctx, c)
+	if err != nil {
+		return err
+	}
+	return cli.ContainerPause(ctx, id)
+}
+
+func (tc *Testcontainers) Unpause(ctx context.Context, c Container) error {
+	cli, id, err := tc.docker(ctx, c)
+	if err != nil {
+		return err
+	}
+	return cli.ContainerUnpause(ctx, id)
+}
+
+func (tc *Testcontainers) Kill(ctx context.Context, c Container) error {
+	cli, id, err := tc.docker(ctx, c)
+	if err != nil {
+		return err
+	}
+	return cli.ContainerKill(ctx, id, "SIGKILL")
+}
+
+func (tc *Testcontainers) Restart(ctx context.Context, c Container, timeout *time.Duration) error {
+	cli, id, err := tc.docker(ctx, c)
+	if err != nil {
+		return err
+	}
+	var opts container.StopOptions
+	if timeout != nil {
+		seconds := int(timeout.Seconds())
+		opts.Timeout = &seconds
+	}
+	return cli.ContainerRestart(ctx, id, opts)
+}
+
+func (tc *Testcontainers) InspectContainer(ctx context.Context, c Container) ([]byte, error) {
+	cli, id, err := tc.docker(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	info, err := cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(info, "", "  ")
+}
+
+func (tc *Testcontainers) InspectNetwork(ctx context.Context, n Network) ([]byte, error) {
+	cli, err := tc.dockerClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info, err := cli.NetworkInspect(ctx, n.ID, types.NetworkInspectOptions{Verbose: true})
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(info, "", "  ")
+}
+
+// docker returns a client for the docker daemon along with the container's ID.
+func (tc *Testcontainers) docker(ctx context.Context, c Container) (*testcontainers.DockerClient, string, error) {
+	dc, err := dockerContainer(c)
+	if err != nil {
+		return nil, "", err
+	}
+	cli, err := tc.dockerClient(ctx)
+	return cli, dc.GetContainerID(), err
+}
+
+func (tc *Testcontainers) dockerClient(ctx context.Context) (cli *testcontainers.DockerClient, err error) {
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	if tc.client == nil {
+		tc.client, err = testcontainers.NewDockerClientWithOpts(ctx)
+	}
+	return tc.client, err
+}
+
+func dockerContainer(c Container) (testcontainers.Container, error) {
+	if dc, ok := c.(testcontainers.Container); ok {
+		return dc, nil
+	}
+	return nil, fmt.Errorf("container %T was not created by this backend", c)
+}
+
+func (*Testcontainers) Exec(ctx context.Context, c Container, cmd []string) (int, []byte, error) {
+	dc, err := dockerContainer(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	code, r, err := dc.Exec(ctx, cmd, tcexec.Multiplexed())
+	if err != nil {
+		return code, nil, err
+	}
+	out, err := io.ReadAll(r)
+	return code, out, err
+}
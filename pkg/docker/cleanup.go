@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// resource is a network, container or process created by the context that has to be removed.
+type resource struct {
+	name    string
+	timeout time.Duration
+	remove  func(context.Context) error
+	// shared resources may be used by other contexts attached to the shared environment.
+	shared bool
+}
+
+// track registers a resource to be removed by Close. The returned func removes it early, it does nothing once the resource is removed.
+// Shared resources are only removed by Close of the last context using them, their returned func does nothing.
+func (ctx *MainContext) track(name string, timeout time.Duration, shared bool, remove func(context.Context) error) func() {
+	r := &resource{name: name, timeout: timeout, remove: remove, shared: shared}
+	ctx.resourcesL.Lock()
+	ctx.resources = append(ctx.resources, r)
+	ctx.resourcesL.Unlock()
+	if shared {
+		return func() {}
+	}
+	return func() { ctx.release(r) }
+}
+
+// release removes r, recording the error to be reported by Cancel.
+func (ctx *MainContext) release(r *resource) {
+	ctx.resourcesL.Lock()
+	i := slices.Index(ctx.resources, r)
+	if i >= 0 {
+		ctx.resources = slices.Delete(ctx.resources, i, i+1)
+	}
+	ctx.resourcesL.Unlock()
+	if i < 0 {
+		return
+	}
+	c, cn := context.WithTimeout(context.Background(), r.timeout)
+	defer cn()
+	if err := r.remove(c); err != nil {
+		ctx.cleanupErrs.Add(fmt.Errorf("removing %s: %w", r.name, err))
+	}
+}
+
+// Close removes every network, container and process created by the context that has not been cleaned up yet, newest first.
+// Each removal has its own timeout. Close then calls [MainContext.Cancel], which reports every removal that failed.
+// In a shared environment, resources used by other attached contexts are left running. See [WithShared].
+// It is safe to call Close more than once. When the context is created with a testing instance that has a Cleanup method, Close is registered with it.
+func (ctx *MainContext) Close() {
+	ctx.t.Helper()
+	if ctx.shared == nil {
+		ctx.releaseAll()
+	} else {
+		ctx.cleanupErrs.Add(ctx.shared.detach(func(last bool) {
+			if !last {
+				ctx.leaveShared()
+			}
+			ctx.releaseAll()
+		}))
+	}
+	ctx.Cancel()
+}
+
+// leaveShared stops tracking the shared resources, leaving them to the other users of the environment.
+func (ctx *MainContext) leaveShared() {
+	ctx.resourcesL.Lock()
+	defer ctx.resourcesL.Unlock()
+	ctx.resources = slices.DeleteFunc(ctx.resources, func(r *resource) bool {
+		if r.shared {
+			ctx.t.Logf("leaving %s to the other users of shared environment %q", r.name, ctx.shared.name)
+		}
+		return r.shared
+	})
+}
+
+// releaseAll removes every tracked resource, newest first.
+func (ctx *MainContext) releaseAll() {
+	for {
+		ctx.resourcesL.Lock()
+		if len(ctx.resources) == 0 {
+			ctx.resourcesL.Unlock()
+			break
+		}
+		r := ctx.resources[len(ctx.resources)-1]
+		ctx.resourcesL.Unlock()
+		ctx.t.Logf("removing %s", r.name)
+		ctx.release(r)
+	}
+}
@@ -2,9 +2,11 @@
 package docker
 
 import (
-	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/go-connections/nat"
 	"github.com/point-c/integration/pkg/archive"
 	"github.com/point-c/integration/pkg/errs"
@@ -13,18 +15,64 @@ import (
 	"github.com/testcontainers/testcontainers-go/network"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"io"
+	"maps"
 	"os"
-	"path/filepath"
+	"regexp"
+	"slices"
 	"sync"
 	"time"
 )
 
+// ImageRepository is the repository the client and server images are tagged in.
+const ImageRepository = "point-c-integration"
+
 const (
 	DockerfileName = "Dockerfile"
 	CaddyfileName  = "Caddyfile"
 	LogName        = "caddy.log"
+	SeedName       = "seed.txt"
 )
 
+// startupLogs select the entries logged by caddy once the tunnel is up and the config is loaded.
+var startupLogs = []LogFilter{TunnelUp, ConfigLoaded}
+
+// waitForLogs is a docker wait strategy that waits until every filter has selected an entry of the container's logs.
+type waitForLogs []LogFilter
+
+func (w waitForLogs) WaitUntilReady(ctx context.Context, target wait.StrategyTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	tick := time.NewTicker(time.Millisecond * 100)
+	defer tick.Stop()
+	for {
+		var logs LogStream
+		if r, err := target.Logs(ctx); err == nil {
+			_, err = io.Copy(&logs, r)
+			_ = r.Close()
+			ready := err == nil
+			for _, f := range w {
+				ready = ready && len(logs.Filter(0, f)) > 0
+			}
+			if ready {
+				return nil
+			}
+		}
+		if state, err := target.State(ctx); err != nil {
+			return err
+		} else if state.Status == "exited" || state.Status == "dead" {
+			if e := logs.Entries(0); len(e) > 0 {
+				return fmt.Errorf("caddy exited before starting, last log: %s", e[len(e)-1].Raw)
+			}
+			return errors.New("caddy exited before starting")
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for caddy to start: %w", ctx.Err())
+		case <-tick.C:
+		}
+	}
+}
+
 // MainContext contains the overall context for the application and configs.
 type MainContext struct {
 	context.Context
@@ -33,106 +81,260 @@ type MainContext struct {
 	Client MainContextEntry[templates.DotClient]
 	Server MainContextEntry[templates.DotServer]
 	Now    time.Time
+	// Seed is the seed used to generate the configs. See [templates.SeedEnv].
+	Seed int64
+	// Templates are the templates used to generate the configs.
+	Templates templates.Set
+	// Label is the name of the matrix combination being tested. It is empty when no matrix is used.
+	Label    string
+	local    bool
+	backend  Backend
+	redacted bool
+	archiver archive.Archiver
+	logGuard bool
+	logAllow []*regexp.Regexp
+	checked  sync.Once
+	debug    debug
+	// cleanupErrs holds the resources that failed to be removed, they are reported by Cancel.
+	cleanupErrs *errs.Collector
+	resourcesL  sync.Mutex
+	resources   []*resource
+	// shared is set when attached to a shared environment, slot is the client of the shared server used by this context.
+	shared *shared
+	slot   int
+}
+
+// Matrix returns every combination of client and server versions in the module manifests.
+// Each combination can be passed to [NewMainContext] with [WithCombination].
+func Matrix(t errs.Testing, opts ...Option) []templates.Combination {
+	t.Helper()
+	return templates.Matrix(t, templates.LoadDir(t, newOptions(opts).templateDir))
 }
 
 // NewMainContext creates a new context. clientDirective is passed to the client's Caddyfile as the handler for the `:80` route.
-func NewMainContext(t errs.Testing, clientDirective string) *MainContext {
+func NewMainContext(t errs.Testing, clientDirective string, opts ...Option) *MainContext {
 	_ = os.Mkdir("test_output", os.ModePerm)
+	o := newOptions(opts)
 	ctx := MainContext{
-		t:   t,
-		Now: time.Now(),
+		t:         t,
+		Now:       time.Now(),
+		Templates: templates.LoadDir(t, o.templateDir),
+		local:     *o.local,
+		backend:   o.backend,
+		redacted:  o.redact,
+		archiver:  o.archiver,
+		logGuard:  o.logGuard,
+		logAllow:  o.logAllow,
+	}
+	ctx.cleanupErrs = errs.NewCollector(t)
+	if ctx.local && !o.backendSet {
+		ctx.backend = localBackend{}
 	}
 	ctx.Context, ctx.cancel = context.WithDeadline(context.Background(), TestingDeadline(t))
 
 	ctx.Client.p = &ctx
 	ctx.Server.p = &ctx
-	ctx.Client.Config, ctx.Server.Config = templates.NewDotPair(t)
+	gen := templates.NewGeneratorFromEnv(t)
+	family := o.family
+	if family == nil {
+		f := templates.FamilyFromEnv(t)
+		family = &f
+	}
+	if o.shared != "" && !ctx.local {
+		// Only the server is shared, so servers of other versions or families need their own environment
+		name := o.shared
+		if c := o.combination; c != nil && c.Server.Name != "" {
+			name += "-" + c.Server.Name
+		}
+		if *family != templates.IPv4 {
+			name += "-" + family.String()
+		}
+		ctx.shared = newShared(name)
+		seed, slot := errs.Must2(ctx.shared.attach(gen.Seed()))(t)
+		gen, ctx.slot = templates.NewGenerator(seed), slot
+		t.Logf("attached to shared environment %q as client %d", name, slot)
+	}
+	ctx.Seed = gen.Seed()
+	t.Logf("generating configs with seed %[2]s, set %[1]s=%[2]s to reproduce", templates.SeedEnv, gen)
+	t.Logf("using %s tunnel addresses", *family)
+	if ctx.shared != nil {
+		// Every user generates the same server, with a peer for every slot, and uses the client of its own slot
+		server, clients := templates.NewTopology(t, SharedClients, templates.WithGenerator(gen), templates.WithFamily(*family))
+		for i, c := range clients[1:] {
+			server.Forwards = append(server.Forwards, templates.DotServerForward{NetworkName: c.NetworkName, Port: uint16(forwardPort(i + 1).Int())})
+		}
+		ctx.Client.Config, ctx.Server.Config = clients[ctx.slot], server
+	} else {
+		ctx.Client.Config, ctx.Server.Config = templates.NewDotPair(t, templates.WithGenerator(gen), templates.WithFamily(*family))
+	}
 	ctx.Client.Config.Directive = clientDirective
+	if ctx.local {
+		ctx.useLocalPorts()
+	}
 
+	clientDockerfile := templates.DeJSON[templates.Manifest](t, ctx.Templates.ClientConfig).DotDockerfile
+	serverDockerfile := templates.DeJSON[templates.Manifest](t, ctx.Templates.ServerConfig).DotDockerfile
+	if c := o.combination; c != nil {
+		ctx.Label = c.Name
+		clientDockerfile, serverDockerfile = c.Client.Dockerfile, c.Server.Dockerfile
+		t.Logf("testing matrix combination %q", ctx.Label)
+	}
 	ctx.Client.Dockerfile, ctx.Server.Dockerfile = archive.Entry[[]byte]{
 		Name:    DockerfileName,
 		Time:    ctx.Now,
-		Content: templates.DeJSON[templates.DotDockerfile](t, templates.ClientConfig).ApplyTemplate(t),
+		Content: clientDockerfile.ApplyTemplateSet(t, ctx.Templates),
 	}, archive.Entry[[]byte]{
 		Name:    DockerfileName,
 		Time:    ctx.Now,
-		Content: templates.DeJSON[templates.DotDockerfile](t, templates.ServerConfig).ApplyTemplate(t),
+		Content: serverDockerfile.ApplyTemplateSet(t, ctx.Templates),
 	}
+	ctx.Client.Sources = SourceEntries(t, ctx.Now, clientDockerfile)
+	ctx.Server.Sources = SourceEntries(t, ctx.Now, serverDockerfile)
 	ctx.Client.Caddyfile, ctx.Server.Caddyfile = archive.Entry[[]byte]{
 		Name:    CaddyfileName,
 		Time:    ctx.Now,
-		Content: caddyfile.Format(ctx.Client.Config.ApplyTemplate(t)),
+		Content: caddyfile.Format(ctx.Client.Config.ApplyTemplateSet(t, ctx.Templates)),
 	}, archive.Entry[[]byte]{
 		Name:    CaddyfileName,
 		Time:    ctx.Now,
-		Content: caddyfile.Format(ctx.Server.Config.ApplyTemplate(t)),
+		Content: caddyfile.Format(ctx.Server.Config.ApplyTemplateSet(t, ctx.Templates)),
+	}
+	ctx.debug.collectors = ctx.defaultCollectors()
+	ctx.Mark("context created with seed %d", ctx.Seed)
+	if t, ok := t.(interface{ Cleanup(func()) }); ok {
+		t.Cleanup(ctx.Close)
 	}
 	return &ctx
 }
 
-func (ctx *MainContext) Cancel() { ctx.cancel() }
-
-// WriteDebugZip writes information about the caddy processes for debugging.
-// The zip contains the server and client's caddyfile and dockerfile, along with any logs if they exist.
-func (ctx *MainContext) WriteDebugZip() {
-	f := errs.Must(os.Create(filepath.Join("test_output", ctx.Now.Format("2006-01-02T15:04:05Z07:00")+".zip")))(ctx.t)
-	defer errs.Defer(ctx.t, f.Close)
-	archive.Archive[archive.Zip](ctx.t, f,
-		archive.Entry[[]archive.FileHeader]{
-			Name: "client",
-			Time: ctx.Now,
-			Content: []archive.FileHeader{
-				ctx.Client.Caddyfile,
-				ctx.Client.Dockerfile,
-				archive.Entry[[]byte]{Name: LogName, Time: ctx.Now, Content: ctx.Client.Logs.Bytes()},
-			},
-		},
-		archive.Entry[[]archive.FileHeader]{
-			Name: "server",
-			Time: ctx.Now,
-			Content: []archive.FileHeader{
-				ctx.Server.Caddyfile,
-				ctx.Server.Dockerfile,
-				archive.Entry[[]byte]{Name: LogName, Time: ctx.Now, Content: ctx.Server.Logs.Bytes()},
-			},
-		},
-	)
+// Cancel cancels the context. If [WithLogGuard] was used the logs are checked the first time it is called.
+// Every network and container that failed to be removed since the last call is reported along with the logs, so one failure does not hide the others.
+func (ctx *MainContext) Cancel() {
+	ctx.t.Helper()
+	ctx.cancel()
+	if ctx.logGuard {
+		ctx.checked.Do(func() { ctx.CheckLogs(ctx.cleanupErrs) })
+	}
+	ctx.cleanupErrs.Report()
+}
+
+// Local reports if the client and server run as child processes instead of docker containers. See [WithLocal].
+func (ctx *MainContext) Local() bool { return ctx.local }
+
+// Labelled prefixes s with the matrix combination label if there is one.
+func (ctx *MainContext) Labelled(s string) string {
+	if ctx.Label == "" {
+		return s
+	}
+	return "[" + ctx.Label + "] " + s
 }
 
 // GetInternalNet gets a docker network with no external connection.
-func (ctx *MainContext) GetInternalNet() (*testcontainers.DockerNetwork, func()) {
+func (ctx *MainContext) GetInternalNet() (Network, func()) {
 	return ctx.GetNet(network.WithInternal())
 }
 
-// GetNet gets a network with the given options. Use the returned func to cleanup the container after usage.
-func (ctx *MainContext) GetNet(opts ...network.NetworkCustomizer) (*testcontainers.DockerNetwork, func()) {
+// GetNet gets a network with the given options. It is removed by [MainContext.Close], the returned func removes it early.
+// When running locally no network is created. Failing to remove the network is reported by [MainContext.Cancel].
+func (ctx *MainContext) GetNet(opts ...network.NetworkCustomizer) (Network, func()) {
 	c, cn := context.WithTimeout(ctx, time.Second*10)
 	defer cn()
-	internalNet := errs.Must(network.New(c, append(opts, network.WithCheckDuplicate(), network.WithAttachable())...))(ctx.t)
-	return internalNet, func() {
-		c, cn := context.WithTimeout(context.Background(), time.Second*10)
-		defer cn()
-		errs.Check(ctx.t, internalNet.Remove(c))
-	}
+	opts = append(opts, network.WithCheckDuplicate(), network.WithAttachable())
+	n := errs.Must(ctx.backend.CreateNetwork(c, opts...))(ctx.t)
+	ctx.Mark("network %s created", n.Name)
+	ctx.debug.l.Lock()
+	ctx.debug.networks = append(ctx.debug.networks, n)
+	ctx.debug.l.Unlock()
+	return n, ctx.track("network "+n.Name, time.Second*10, false, func(c context.Context) error {
+		ctx.debug.l.Lock()
+		ctx.debug.networks = slices.DeleteFunc(ctx.debug.networks, func(dn Network) bool { return dn == n })
+		ctx.debug.l.Unlock()
+		if err := ctx.backend.RemoveNetwork(c, n); err != nil {
+			return err
+		}
+		ctx.Mark("network %s removed", n.Name)
+		return nil
+	})
 }
 
 // GetContainer creates a new docker container with the given request. The container will be started before returning.
-// Use cleanup to remove all container resources after running.
-func (ctx *MainContext) GetContainer(req testcontainers.ContainerRequest) (tc testcontainers.Container, cleanup func()) {
+// It is stopped by [MainContext.Close], cleanup stops it early. Containers cannot be created when running locally.
+// Failing to stop the container is reported by [MainContext.Cancel].
+func (ctx *MainContext) GetContainer(req testcontainers.ContainerRequest) (Container, func()) {
 	c, cn := context.WithTimeout(ctx, time.Minute*5)
 	defer cn()
-	tc = errs.Must(testcontainers.GenericContainer(c, testcontainers.GenericContainerRequest{
-		ContainerRequest: req,
-		Started:          true,
-	}))(ctx.t)
-	return tc, func() {
-		c, cn := context.WithTimeout(context.Background(), time.Second*20)
-		defer cn()
-		to := time.Second * 10
-		errs.Check(ctx.t, tc.Stop(c, &to))
+	return ctx.addContainer(req.Name, false, errs.Must(ctx.backend.StartContainer(c, req))(ctx.t))
+}
+
+// getSharedContainer attaches to the container called req.Name in the shared environment, starting it if there is none.
+// It fails if the existing container has a different [LabelDigest] label. The container is connected to the networks of req,
+// they are disconnected again before the networks are removed. The container itself is only stopped by the last user of the environment.
+func (ctx *MainContext) getSharedContainer(req testcontainers.ContainerRequest) (tc Container, cleanup func()) {
+	c, cn := context.WithTimeout(ctx, time.Minute*5)
+	defer cn()
+	req.Labels = maps.Clone(req.Labels)
+	if req.Labels == nil {
+		req.Labels = map[string]string{}
+	}
+	req.Labels[LabelEnv] = ctx.shared.name
+	errs.Check(ctx.t, ctx.shared.update(func(*sharedState) {
+		var labels map[string]string
+		if tc, labels = errs.Must2(ctx.backend.FindContainer(c, req))(ctx.t); tc == nil {
+			tc = errs.Must(ctx.backend.StartContainer(c, req))(ctx.t)
+			return
+		} else if labels[LabelDigest] != req.Labels[LabelDigest] {
+			errs.Check(ctx.t, fmt.Errorf("shared environment %q already has a container called %s with a different config", ctx.shared.name, req.Name))
+		}
+		ctx.Mark("container %s attached", req.Name)
+		for _, n := range req.Networks {
+			errs.Check(ctx.t, ctx.backend.ConnectNetwork(c, tc, n))
+		}
+	}))
+	tc, cleanup = ctx.addContainer(req.Name, true, tc)
+	// Only networks made by this context are disconnected, the others may still be used by other users
+	ctx.debug.l.Lock()
+	own := slices.DeleteFunc(slices.Clone(req.Networks), func(n string) bool {
+		return !slices.ContainsFunc(ctx.debug.networks, func(dn Network) bool { return dn.Name == n })
+	})
+	ctx.debug.l.Unlock()
+	for _, n := range own {
+		ctx.track(fmt.Sprintf("connection of container %s to network %s", req.Name, n), time.Second*10, false, func(c context.Context) error {
+			return ctx.backend.DisconnectNetwork(c, tc, n)
+		})
+	}
+	return tc, cleanup
+}
+
+// addContainer tracks the started container and adds it to the debug zip.
+func (ctx *MainContext) addContainer(name string, shared bool, tc Container) (Container, func()) {
+	ctx.debug.l.Lock()
+	if name == "" {
+		name = fmt.Sprintf("container-%d", len(ctx.debug.containers)+1)
 	}
+	// Stopped containers are kept, since their state is useful for debugging
+	ctx.debug.containers = append(ctx.debug.containers, namedContainer{name: name, c: tc})
+	ctx.debug.l.Unlock()
+	ctx.Mark("container %s started", name)
+	return tc, ctx.track("container "+name, time.Second*20, shared, func(c context.Context) error {
+		to := time.Second * 10
+		if err := ctx.backend.StopContainer(c, tc, &to); err != nil {
+			return err
+		}
+		ctx.Mark("container %s stopped", name)
+		return nil
+	})
 }
 
+// ForwardPort is the port of the server that is forwarded through the tunnel to port 80 of the client.
+// It is 80/tcp, unless attached to a shared environment where the client of every user has its own port.
+func (ctx *MainContext) ForwardPort() nat.Port { return forwardPort(ctx.slot) }
+
+// Shared reports if the context is attached to a shared environment. The server is then used by other contexts too.
+func (ctx *MainContext) Shared() bool { return ctx.shared != nil }
+
+// forwardPort is the port of the shared server forwarded to the client in slot.
+func forwardPort(slot int) nat.Port { return nat.Port(fmt.Sprintf("%d/tcp", 80+slot)) }
+
 type (
 	// MainContextEntry is either a server or client definition.
 	MainContextEntry[D interface {
@@ -142,8 +344,23 @@ type (
 		p          *MainContext
 		Dockerfile archive.Entry[[]byte]
 		Caddyfile  archive.Entry[[]byte]
-		Config     D
-		Logs       lockedBuf
+		// Sources are local source trees that are added to the build context.
+		Sources []archive.FileHeader
+		Config  D
+		Logs    LogStream
+		// BuildLog is the output of building the image.
+		BuildLog lockedBuf
+		// BaseImage is the Caddy image built from Dockerfile and Sources, it is shared by every config with the same modules.
+		// Image adds the Caddyfile to it. Both are tagged with the digest of their build context, Manifest describes the context of Image.
+		// BaseImage is empty if Dockerfile has [CopiesCaddyfileMarker], Image is then built from Dockerfile directly.
+		BaseImage string
+		Image     string
+		Manifest  archive.Manifest
+		ports     map[nat.Port]nat.Port
+		// container is set once the container is started.
+		container Container
+		// logsCtx stops following the logs when the container is cleaned up.
+		logsCtx context.Context
 	}
 	// NamedNetwork is used to specify the server and client data.
 	NamedNetwork interface {
@@ -151,32 +368,46 @@ type (
 	}
 )
 
-// StartContainer starts the container specified by this configuration.
-func (mce *MainContextEntry[D]) StartContainer(networks []string, exposed []string, waitPort ...nat.Port) (testcontainers.Container, func()) {
-	// Generate dockerfile context
-	var buf bytes.Buffer
-	archive.Archive[archive.Tar](mce.p.t, &buf, mce.Caddyfile, mce.Dockerfile)
-	// Start container
-	waitFor := []wait.Strategy{
-		wait.ForLog(`{"level":"info","ts":[0-9]+\.[0-9]+,"msg":"Interface state changed","Old":"Down","Want":"Up","Now":"Up"}`).AsRegexp(),
-		wait.ForLog(`{"level":"info","ts":[0-9]+\.[0-9]+,"msg":"serving initial configuration"}`).AsRegexp(),
+// StartContainer starts the container specified by this configuration. It is stopped by [MainContext.Close], the returned func stops it early.
+// When running locally a child process is started instead, networks and exposed are ignored.
+// In a shared environment the server is shared with the other users. It exposes the forwarded port of every user instead of exposed,
+// and is connected to the networks of every user. It is only stopped when the last user closes its context.
+func (mce *MainContextEntry[D]) StartContainer(networks []string, exposed []string, waitPort ...nat.Port) (Container, func()) {
+	if mce.p.local {
+		return mce.startLocal(waitPort...)
 	}
+	mce.buildImage()
+
+	// Start container
+	name := mce.Config.GetNetworkName()
+	waitFor := []wait.Strategy{waitForLogs(startupLogs)}
 	if len(waitPort) > 0 {
 		waitFor = append(waitFor, wait.ForListeningPort(waitPort[0]))
 	}
 
-	logsCtx, logsCancel := context.WithCancel(mce.p)
-	c, cleanup := mce.p.GetContainer(testcontainers.ContainerRequest{
-		FromDockerfile: testcontainers.FromDockerfile{
-			ContextArchive: &buf,
-			PrintBuildLog:  true,
-		},
-		Name:         mce.Config.GetNetworkName(),
-		Hostname:     mce.Config.GetNetworkName(),
+	req := testcontainers.ContainerRequest{
+		Image:        mce.Image,
+		Name:         name,
+		Hostname:     name,
 		Networks:     networks,
 		ExposedPorts: exposed,
 		WaitingFor:   wait.ForAll(waitFor...),
-	})
+		// Allows the link to be impaired with tc
+		HostConfigModifier: func(hc *container.HostConfig) { hc.CapAdd = append(hc.CapAdd, "NET_ADMIN") },
+	}
+	logsCtx, logsCancel := context.WithCancel(mce.p)
+	var c Container
+	var cleanup func()
+	if mce.shared() {
+		req.Labels = map[string]string{LabelDigest: mce.Manifest.Digest}
+		req.ExposedPorts = nil
+		for i := 0; i < SharedClients; i++ {
+			req.ExposedPorts = append(req.ExposedPorts, string(forwardPort(i)))
+		}
+		c, cleanup = mce.p.getSharedContainer(req)
+	} else {
+		c, cleanup = mce.p.GetContainer(req)
+	}
 	cleanup = func(f func()) func() { return func() { logsCancel(); f() } }(cleanup)
 
 	panicked := true
@@ -186,27 +417,23 @@ func (mce *MainContextEntry[D]) StartContainer(networks []string, exposed []stri
 		}
 	}()
 
-	go func() {
-		_, _ = io.Copy(&mce.Logs, errs.Must(c.Logs(logsCtx))(mce.p.t))
-	}()
+	mce.container, mce.logsCtx = c, logsCtx
+	mce.followLogs(time.Time{})
 
 	panicked = false
 	return c, cleanup
 }
 
-type lockedBuf struct {
-	b bytes.Buffer
-	l sync.Mutex
+// shared reports if this is the server of a shared environment.
+func (mce *MainContextEntry[D]) shared() bool {
+	return mce.p.shared != nil && any(mce) == any(&mce.p.Server)
 }
 
-func (l *lockedBuf) Bytes() []byte {
-	l.l.Lock()
-	defer l.l.Unlock()
-	return l.b.Bytes()
-}
-
-func (l *lockedBuf) Write(p []byte) (n int, err error) {
-	l.l.Lock()
-	defer l.l.Unlock()
-	return l.b.Write(p)
+// followLogs copies the container's logs, starting at since, into Logs until the container stops.
+func (mce *MainContextEntry[D]) followLogs(since time.Time) {
+	logs := errs.Must(mce.p.backend.ContainerLogs(mce.logsCtx, mce.container, since))(mce.p.t)
+	go func() {
+		defer logs.Close()
+		_, _ = io.Copy(&mce.Logs, logs)
+	}()
 }
@@ -0,0 +1,522 @@
+package docker
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-connections/nat"
+	"github.com/point-c/integration/pkg/archive"
+	"github.com/point-c/integration/pkg/errs"
+	"github.com/point-c/integration/pkg/errs/errstest"
+	"github.com/point-c/integration/pkg/templates"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackendEnv(t *testing.T) {
+	t.Setenv(BackendEnv, BackendLocal)
+	f := new(Fake)
+	ctx := NewMainContext(t, "", WithBackend(f))
+	defer ctx.Close()
+	require.False(t, ctx.Local(), "an explicit backend must not be replaced by the local backend")
+	require.Same(t, f, ctx.backend)
+
+	ctx = NewMainContext(t, "")
+	defer ctx.Close()
+	require.True(t, ctx.Local())
+	require.Equal(t, localBackend{}, ctx.backend)
+}
+
+func TestGetNet(t *testing.T) {
+	f := new(Fake)
+	ctx := NewMainContext(t, "", WithBackend(f))
+	defer ctx.Cancel()
+
+	n, cleanup := ctx.GetInternalNet()
+	require.Equal(t, "fake-network-1-internal", n.Name)
+	cleanup()
+	require.Equal(t, []string{"create-network " + n.Name, "remove-network " + n.Name}, f.Events())
+}
+
+func TestStartContainer(t *testing.T) {
+	const log = `{"level":"info","ts":1.5,"msg":"serving initial configuration"}`
+	f := &Fake{Logs: func(req testcontainers.ContainerRequest) string { return req.Name + log }}
+	ctx := NewMainContext(t, "", WithBackend(f))
+	defer ctx.Cancel()
+
+	c, cleanup := ctx.Server.StartContainer(nil, []string{"80/tcp"}, "80/tcp")
+	port, err := c.MappedPort(ctx, "80/tcp")
+	require.NoError(t, err)
+	require.Equal(t, "80/tcp", string(port))
+	require.Eventually(t, func() bool {
+		return string(ctx.Server.Logs.Bytes()) == ctx.Server.Config.NetworkName+log
+	}, time.Second, time.Millisecond*10)
+	cleanup()
+
+	name := ctx.Server.Config.NetworkName
+	require.Equal(t, []string{
+		"image-exists " + ctx.Server.BaseImage,
+		"build-image " + ctx.Server.BaseImage,
+		"image-exists " + ctx.Server.Image,
+		"build-image " + ctx.Server.Image,
+		"start-container " + name,
+		"container-logs " + name,
+		"stop-container " + name,
+	}, f.Events())
+}
+
+func TestWaitForLogs(t *testing.T) {
+	up := `{"level":"info","ts":1700000000.5,"msg":"Interface state changed","Old":"Down","Want":"Up","Now":"Up"}` + "\n"
+	loaded := `{"level":"info","ts":1700000001,"msg":"serving initial configuration"}` + "\n"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	require.NoError(t, waitForLogs(startupLogs).WaitUntilReady(ctx, &logTarget{logs: up + loaded, status: "running"}))
+	err := waitForLogs(startupLogs).WaitUntilReady(ctx, &logTarget{logs: up, status: "exited"})
+	require.ErrorContains(t, err, "caddy exited before starting", "caddy must not be ready until every entry is logged")
+}
+
+// logTarget is a [wait.StrategyTarget] with fixed logs and status.
+type logTarget struct {
+	wait.StrategyTarget
+	logs, status string
+}
+
+func (l *logTarget) Logs(context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(l.logs)), nil
+}
+
+func (l *logTarget) State(context.Context) (*types.ContainerState, error) {
+	return &types.ContainerState{Status: l.status}, nil
+}
+
+func TestFamily(t *testing.T) {
+	ctx := NewMainContext(t, "", WithBackend(new(Fake)), WithFamily(templates.IPv6))
+	defer ctx.Cancel()
+	require.Nil(t, ctx.Client.Config.IP.To4())
+	require.Nil(t, ctx.Server.Config.IP.To4())
+
+	t.Setenv(templates.FamilyEnv, "IPv6")
+	ctx = NewMainContext(t, "", WithBackend(new(Fake)))
+	defer ctx.Cancel()
+	require.Nil(t, ctx.Server.Config.IP.To4(), "the family must be read from the environment")
+
+	t.Setenv(templates.FamilyEnv, "ipv5")
+	r := errstest.NewRecorder(t)
+	require.True(t, r.Failed(func() { NewMainContext(r, "", WithBackend(new(Fake))) }))
+}
+
+func TestStartContainerLogsFail(t *testing.T) {
+	f := &Fake{Err: func(event string) error {
+		if strings.HasPrefix(event, "container-logs ") {
+			return errors.New("no logs")
+		}
+		return nil
+	}}
+	r := errstest.NewRecorder(t)
+	ctx := NewMainContext(r, "", WithBackend(f))
+	defer ctx.Cancel()
+
+	require.True(t, r.Failed(func() { ctx.Client.StartContainer(nil, nil) }))
+	require.Equal(t, []string{"no logs"}, r.Errs())
+	name := ctx.Client.Config.NetworkName
+	require.Equal(t, []string{"start-container " + name, "stop-container " + name}, containerEvents(f), "container must be stopped if starting fails")
+}
+
+func TestCleanupOrder(t *testing.T) {
+	f := new(Fake)
+	ctx := NewMainContext(t, "", WithBackend(f))
+	defer ctx.Cancel()
+
+	func() {
+		n, cleanup := ctx.GetInternalNet()
+		defer cleanup()
+		_, cleanup = ctx.Server.StartContainer([]string{n.Name}, nil)
+		defer cleanup()
+		_, cleanup = ctx.Client.StartContainer([]string{n.Name}, nil)
+		defer cleanup()
+	}()
+
+	server, client := ctx.Server.Config.NetworkName, ctx.Client.Config.NetworkName
+	require.Equal(t, []string{
+		"create-network fake-network-1-internal",
+		"start-container " + server,
+		"container-logs " + server,
+		"start-container " + client,
+		"container-logs " + client,
+		"stop-container " + client,
+		"stop-container " + server,
+		"remove-network fake-network-1-internal",
+	}, containerEvents(f))
+}
+
+func TestClose(t *testing.T) {
+	f := new(Fake)
+	ctx := NewMainContext(t, "", WithBackend(f))
+	n, _ := ctx.GetInternalNet()
+	ctx.Server.StartContainer([]string{n.Name}, nil)
+	_, cleanup := ctx.GetContainer(testcontainers.ContainerRequest{Name: "helper"})
+	_, _ = ctx.GetContainer(testcontainers.ContainerRequest{Name: "other"})
+	cleanup()
+	ctx.Close()
+	ctx.Close()
+	cleanup()
+
+	events := f.Events()
+	require.Equal(t, []string{
+		"stop-container helper",
+		"stop-container other",
+		"stop-container " + ctx.Server.Config.NetworkName,
+		"remove-image " + ctx.Server.Image,
+		"remove-network " + n.Name,
+	}, events[len(events)-5:], "resources must be removed once, newest first")
+	require.Error(t, ctx.Err())
+}
+
+func TestImageReuse(t *testing.T) {
+	f := new(Fake)
+	var base string
+	var images []string
+	for _, seed := range []string{"1234", "1234", "5678"} {
+		t.Setenv(templates.SeedEnv, seed)
+		ctx := NewMainContext(t, "", WithBackend(f))
+		ctx.Server.StartContainer(nil, nil)
+		ctx.Close()
+		require.Equal(t, ImageRepository+":"+ctx.Server.Manifest.Digest, ctx.Server.Image)
+		base = ctx.Server.BaseImage
+		images = append(images, ctx.Server.Image)
+	}
+	var builds, removed []string
+	for _, e := range f.Events() {
+		if tag, ok := strings.CutPrefix(e, "build-image "); ok {
+			builds = append(builds, tag)
+		} else if tag, ok := strings.CutPrefix(e, "remove-image "); ok {
+			removed = append(removed, tag)
+		}
+	}
+	require.Equal(t, images[0], images[1], "unchanged configs must have the same image")
+	require.NotEqual(t, images[0], images[2])
+	require.Equal(t, images, removed, "images with keys must be removed on Close")
+	require.ElementsMatch(t, append([]string{base}, images...), builds, "Caddy must only be built once")
+}
+
+func TestDockerfileCopiesCaddyfile(t *testing.T) {
+	dir := t.TempDir()
+	dockerfile := "FROM caddy:{{ .Caddy }}\n" + CopiesCaddyfileMarker + "\nCOPY . /etc/caddy/\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DockerfileName), []byte(dockerfile), 0o644))
+	f := new(Fake)
+	ctx := NewMainContext(t, "", WithBackend(f), WithTemplateDir(dir))
+	ctx.Server.StartContainer(nil, nil)
+	ctx.Close()
+
+	require.Empty(t, ctx.Server.BaseImage, "overrides with the marker must not use a base image")
+	var names []string
+	for _, file := range ctx.Server.Manifest.Files {
+		names = append(names, file.Name)
+	}
+	require.Subset(t, names, []string{CaddyfileName, DockerfileName})
+	require.Equal(t, []string{"image-exists " + ctx.Server.Image, "build-image " + ctx.Server.Image}, f.Events()[:2])
+
+	dockerfile = "FROM caddy:{{ .Caddy }}\nCOPY Caddyfile.example /etc/caddy/\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DockerfileName), []byte(dockerfile), 0o644))
+	ctx = NewMainContext(t, "", WithBackend(new(Fake)), WithTemplateDir(dir))
+	ctx.Server.StartContainer(nil, nil)
+	ctx.Close()
+	require.NotEmpty(t, ctx.Server.BaseImage, "overrides without the marker must use a base image")
+}
+
+func TestStopContainerFail(t *testing.T) {
+	f := &Fake{Err: func(event string) error {
+		if strings.HasPrefix(event, "stop-container ") {
+			return errors.New("cannot stop")
+		}
+		return nil
+	}}
+	r := errstest.NewRecorder(t)
+	ctx := NewMainContext(r, "", WithBackend(f))
+
+	_, cleanup1 := ctx.GetContainer(testcontainers.ContainerRequest{Name: "helper"})
+	_, cleanup2 := ctx.GetContainer(testcontainers.ContainerRequest{Name: "other"})
+	require.False(t, r.Failed(cleanup2))
+	require.False(t, r.Failed(cleanup1), "every cleanup must run")
+	require.True(t, r.Failed(ctx.Cancel))
+	require.Equal(t, []string{"removing container other: cannot stop", "removing container helper: cannot stop"}, r.Errs())
+	require.False(t, r.Failed(ctx.Cancel), "failures must only be reported once")
+}
+
+func TestImpairLink(t *testing.T) {
+	f := new(Fake)
+	ctx := NewMainContext(t, "", WithBackend(f))
+	defer ctx.Cancel()
+	ctx.Server.StartContainer(nil, nil)
+	ctx.Client.StartContainer(nil, nil)
+
+	ctx.ImpairLink(LossyMobile)()
+	server, client := ctx.Server.Config.NetworkName, ctx.Client.Config.NetworkName
+	exec := func(name, peer, args string) string {
+		return strings.TrimSpace(fmt.Sprintf("exec %s sh -c %s impair %s %s", name, impairScript, peer, args))
+	}
+	const netem = "delay 60ms 30ms loss 3% reorder 1% rate 5000000bit"
+	require.Equal(t, []string{
+		exec(server, client, netem),
+		exec(client, ctx.Client.Config.Endpoint, netem),
+		exec(server, client, ""),
+		exec(client, ctx.Client.Config.Endpoint, ""),
+	}, prefixedEvents(f, "exec "))
+}
+
+func TestLinkProfiles(t *testing.T) {
+	t.Setenv(LinkProfilesEnv, "perfect, bogus,satellite")
+	c := errs.NewCollector(t)
+	ctx := &MainContext{t: c}
+	require.Equal(t, []LinkProfile{Perfect, Satellite}, ctx.LinkProfiles(), "unknown profiles must be skipped when checks do not stop")
+	require.EqualError(t, c.Err(), `unknown link profile "bogus"`)
+}
+
+func TestFaults(t *testing.T) {
+	const logs = `{"level":"info","ts":1.5,"msg":"Interface state changed","Old":"Down","Want":"Up","Now":"Up"}
+{"level":"info","ts":1.5,"msg":"serving initial configuration"}
+`
+	f := &Fake{Logs: func(testcontainers.ContainerRequest) string { return logs }}
+	ctx := NewMainContext(t, "", WithBackend(f))
+	defer ctx.Cancel()
+	ctx.Server.StartContainer(nil, nil)
+
+	ctx.Server.Pause()
+	ctx.Server.Unpause()
+	ctx.Server.Kill()
+	ctx.Server.Restart(time.Second)
+	name := ctx.Server.Config.NetworkName
+	require.Equal(t, []string{
+		"start-container " + name,
+		"container-logs " + name,
+		"pause-container " + name,
+		"unpause-container " + name,
+		"kill-container " + name,
+		"restart-container " + name,
+		"container-logs " + name,
+	}, containerEvents(f))
+}
+
+func TestLogGuard(t *testing.T) {
+	f := &Fake{Logs: func(req testcontainers.ContainerRequest) string {
+		return `{"level":"error","ts":1.5,"msg":"handshake did not complete"}
+{"level":"error","ts":1.5,"msg":"dropped packet"}
+`
+	}}
+	r := errstest.NewRecorder(t)
+	ctx := NewMainContext(r, "", WithBackend(f), WithLogGuard(`"msg":"handshake did not complete"`))
+	_, cleanup := ctx.Server.StartContainer(nil, nil)
+	require.Eventually(t, func() bool { return ctx.Server.Logs.Len() == 2 }, time.Second, time.Millisecond*10)
+	cleanup()
+
+	require.True(t, r.Failed(ctx.Cancel))
+	require.Equal(t, []string{
+		ctx.Server.Config.NetworkName + ` logged an unexpected error: {"level":"error","ts":1.5,"msg":"dropped packet"}`,
+		"1 unexpected error log entries",
+	}, r.Errs())
+	require.False(t, r.Failed(ctx.Cancel), "logs must only be checked once")
+}
+
+func TestRedact(t *testing.T) {
+	ctx := NewMainContext(t, "", WithBackend(new(Fake)), WithRedact(true))
+	defer ctx.Cancel()
+	ctx.WriteDebugZip()
+	files := readDebugZip(t, ctx)
+
+	text := func(k interface{ MarshalText() ([]byte, error) }) string {
+		b, err := k.MarshalText()
+		require.NoError(t, err)
+		return string(b)
+	}
+	client, server := files["client/"+CaddyfileName], files["server/"+CaddyfileName]
+	require.NotContains(t, client, text(ctx.Client.Config.Private))
+	require.NotContains(t, client, text(ctx.Client.Config.Shared))
+	require.Contains(t, client, "<redacted client private key>")
+	require.Contains(t, client, text(ctx.Client.Config.Public), "public keys must not be redacted")
+	require.NotContains(t, server, text(ctx.Server.Config.Private))
+	require.Contains(t, server, "<redacted "+ctx.Client.Config.NetworkName+" preshared key>")
+	require.NotContains(t, files[SeedName], fmt.Sprint(ctx.Seed))
+}
+
+func TestDebugZip(t *testing.T) {
+	f := new(Fake)
+	ctx := NewMainContext(t, "", WithBackend(f), WithRedact(false))
+	defer ctx.Cancel()
+	n, cleanup := ctx.GetInternalNet()
+	defer cleanup()
+	_, cleanup = ctx.Server.StartContainer([]string{n.Name}, nil)
+	defer cleanup()
+	ctx.AddDebugCollector(func(errs.Testing) []archive.FileHeader {
+		return []archive.FileHeader{archive.Entry[[]byte]{Name: "extra.txt", Content: []byte("extra")}}
+	})
+	ctx.WriteDebugZip()
+	files := readDebugZip(t, ctx)
+
+	server := ctx.Server.Config.NetworkName
+	require.Equal(t, fmt.Sprintf("%s=%d\n", templates.SeedEnv, ctx.Seed), files[SeedName])
+	require.Equal(t, "Successfully tagged "+ctx.Server.BaseImage+"\nSuccessfully tagged "+ctx.Server.Image+"\n", files["server/"+BuildLogName])
+	require.Equal(t, string(ctx.Server.Caddyfile.Content), files["server/"+CaddyfileName])
+	require.Contains(t, files, "server/"+DockerfileName)
+	require.Contains(t, files["server/"+ManifestName], ctx.Server.Manifest.Digest)
+	require.Contains(t, files, "client/"+LogName)
+	require.JSONEq(t, `{"Name":"`+server+`","Image":"`+ctx.Server.Image+`","Networks":["`+n.Name+`"]}`, files[DockerDir+"/containers/"+server+".json"])
+	require.JSONEq(t, `{"ID":"1","Name":"`+n.Name+`"}`, files[DockerDir+"/networks/"+n.Name+".json"])
+	require.Contains(t, files[TimelineName], "container "+server+" started")
+	require.Equal(t, "extra", files["extra.txt"])
+}
+
+// containerEvents are the events of f that are not about images.
+func containerEvents(f *Fake) (events []string) {
+	for _, e := range f.Events() {
+		if !strings.HasPrefix(e, "image-exists ") && !strings.HasPrefix(e, "build-image ") {
+			events = append(events, e)
+		}
+	}
+	return
+}
+
+// prefixedEvents returns the events of f starting with prefix.
+func prefixedEvents(f *Fake, prefix string) (events []string) {
+	for _, e := range f.Events() {
+		if strings.HasPrefix(e, prefix) {
+			events = append(events, e)
+		}
+	}
+	return
+}
+
+// readDebugZip reads every file in the context's debug zip.
+func readDebugZip(t *testing.T, ctx *MainContext) map[string]string {
+	z, err := zip.OpenReader(filepath.Join("test_output", ctx.Now.Format("2006-01-02T15:04:05Z07:00")+".zip"))
+	require.NoError(t, err)
+	defer z.Close()
+	files := map[string]string{}
+	for _, f := range z.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		files[f.Name] = string(b)
+	}
+	return files
+}
+
+func TestShared(t *testing.T) {
+	f := new(Fake)
+	env := sharedEnv(t)
+	t.Setenv(templates.SeedEnv, "1234")
+	ctx1 := NewMainContext(t, "", WithBackend(f), WithShared(env))
+	t.Setenv(templates.SeedEnv, "5678")
+	ctx2 := NewMainContext(t, "", WithBackend(f), WithShared(env))
+	require.Equal(t, ctx1.Server.Config, ctx2.Server.Config, "every user must use the server of the environment")
+	require.NotEqual(t, ctx1.Client.Config.NetworkName, ctx2.Client.Config.NetworkName, "every user must have its own client")
+	require.Equal(t, []nat.Port{"80/tcp", "81/tcp"}, []nat.Port{ctx1.ForwardPort(), ctx2.ForwardPort()})
+	require.Equal(t, templates.DotServerForward{NetworkName: ctx2.Client.Config.NetworkName, Port: 81}, ctx1.Server.Config.Forwards[0], "the server must forward the port of every slot to its client")
+
+	n1, _ := ctx1.GetInternalNet()
+	server1, _ := ctx1.Server.StartContainer([]string{"localhost", n1.Name}, nil)
+	ctx1.Client.StartContainer([]string{n1.Name}, nil)
+	n2, _ := ctx2.GetInternalNet()
+	server2, _ := ctx2.Server.StartContainer([]string{"localhost", n2.Name}, nil)
+	ctx2.Client.StartContainer([]string{n2.Name}, nil)
+	require.Same(t, server1, server2)
+	require.Len(t, server1.(*FakeContainer).Request.ExposedPorts, SharedClients, "the forwarded port of every user must be exposed")
+
+	server, client1, client2 := ctx1.Server.Config.NetworkName, ctx1.Client.Config.NetworkName, ctx2.Client.Config.NetworkName
+	require.Equal(t, []string{
+		"create-network " + n1.Name,
+		"find-container " + server,
+		"start-container " + server,
+		"container-logs " + server,
+		"start-container " + client1,
+		"container-logs " + client1,
+		"create-network " + n2.Name,
+		"find-container " + server,
+		"connect-network " + server + " " + n2.Name,
+		"container-logs " + server,
+		"start-container " + client2,
+		"container-logs " + client2,
+	}, containerEvents(f), "the second user must attach to the server of the first")
+
+	r := errstest.NewRecorder(t)
+	ctx2.t = r
+	require.True(t, r.Failed(ctx2.Server.Pause), "faults must not affect the other users")
+	ctx2.t = t
+	ctx2.ImpairLink(LossyMobile)()
+	impair := fmt.Sprintf("exec %s sh -c %s impair %s", client2, impairScript, ctx2.Client.Config.Endpoint)
+	events := f.Events()
+	require.Equal(t, []string{
+		impair + " delay 60ms 30ms loss 3% reorder 1% rate 5000000bit",
+		impair,
+	}, events[len(events)-2:], "only the client side of the link must be impaired")
+
+	ctx1.Close()
+	events = f.Events()
+	require.Equal(t, []string{
+		"stop-container " + client1,
+		"remove-image " + ctx1.Client.Image,
+		"disconnect-network " + server + " " + n1.Name,
+		"remove-network " + n1.Name,
+	}, events[len(events)-4:], "the server must be kept while other users are attached, and stay on networks it did not make")
+	ctx2.Close()
+	events = f.Events()
+	require.Equal(t, []string{
+		"stop-container " + client2,
+		"remove-image " + ctx2.Client.Image,
+		"disconnect-network " + server + " " + n2.Name,
+		"stop-container " + server,
+		"remove-image " + ctx2.Server.Image,
+		"remove-network " + n2.Name,
+	}, events[len(events)-6:])
+}
+
+func TestSharedConflict(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, templates.CaddyfileServerName), []byte("# changed\n"+templates.CaddyfileServer), 0o644))
+	f := new(Fake)
+	env := sharedEnv(t)
+	ctx1 := NewMainContext(t, "", WithBackend(f), WithShared(env))
+	defer ctx1.Close()
+	r := errstest.NewRecorder(t)
+	ctx2 := NewMainContext(r, "", WithBackend(f), WithShared(env), WithTemplateDir(dir))
+	defer ctx2.Close()
+	ctx3 := NewMainContext(t, "", WithBackend(f), WithShared(env), WithFamily(templates.IPv6))
+	defer ctx3.Close()
+
+	ctx1.Server.StartContainer(nil, nil)
+	require.True(t, r.Failed(func() { ctx2.Server.StartContainer(nil, nil) }))
+	require.Equal(t, []string{fmt.Sprintf("shared environment %q already has a container called %s with a different config", env, ctx1.Server.Config.NetworkName)}, r.Errs())
+	// Servers of other families use their own environment
+	ctx3.Server.StartContainer(nil, nil)
+}
+
+func TestSharedSlots(t *testing.T) {
+	env := sharedEnv(t)
+	for i := 0; i < SharedClients; i++ {
+		_, slot, err := newShared(env).attach(1234)
+		require.NoError(t, err)
+		require.Equal(t, i, slot)
+	}
+	_, _, err := newShared(env).attach(1234)
+	require.Error(t, err, "attaching must fail when every slot is used")
+}
+
+// sharedEnv returns the name of a new shared environment, removing its lockfile after the test.
+func sharedEnv(t *testing.T) string {
+	s := newShared("test-" + newShared("").id)
+	t.Cleanup(func() { _ = os.Remove(s.path()) })
+	return s.name
+}
@@ -0,0 +1,211 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/point-c/integration/pkg/archive"
+	"github.com/point-c/integration/pkg/errs"
+	"github.com/point-c/integration/pkg/templates"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AdaptedName  = "caddy.json"
+	BuildLogName = "build.log"
+	TimelineName = "timeline.json"
+	// ManifestName contains the manifest of the image build context.
+	ManifestName = "context.json"
+	// DockerDir contains the output of `docker inspect` for every container and network.
+	DockerDir = "docker"
+)
+
+type (
+	// DebugCollector contributes files to the debug zip. It is called every time the zip is written, so it should return the current state.
+	DebugCollector func(errs.Testing) []archive.FileHeader
+	// debug is the state collected for the debug zip.
+	debug struct {
+		l          sync.Mutex
+		collectors []DebugCollector
+		timeline   []TimelineEvent
+		networks   []Network
+		containers []namedContainer
+	}
+	// TimelineEvent is something that happened during the run, written to the debug zip's timeline.
+	TimelineEvent struct {
+		Time time.Time `json:"time"`
+		// Elapsed is the time since the context was created.
+		Elapsed string `json:"elapsed"`
+		Event   string `json:"event"`
+	}
+	namedContainer struct {
+		name string
+		c    Container
+	}
+)
+
+// AddDebugCollector adds files to every debug zip written after this call.
+func (ctx *MainContext) AddDebugCollector(c DebugCollector) {
+	ctx.debug.l.Lock()
+	defer ctx.debug.l.Unlock()
+	ctx.debug.collectors = append(ctx.debug.collectors, c)
+}
+
+// Mark adds an event to the timeline in the debug zip.
+func (ctx *MainContext) Mark(format string, a ...any) {
+	now := time.Now()
+	ctx.debug.l.Lock()
+	defer ctx.debug.l.Unlock()
+	ctx.debug.timeline = append(ctx.debug.timeline, TimelineEvent{Time: now, Elapsed: now.Sub(ctx.Now).String(), Event: fmt.Sprintf(format, a...)})
+}
+
+// WriteDebugZip writes information about the caddy processes for debugging.
+// The zip contains a folder for the server and client with their caddyfile, the caddyfile adapted to JSON, dockerfile, build log and logs.
+// The seed used to generate the configs and a timeline of the run are written to the root of the zip, along with `docker inspect` output for every container and network.
+// Files from collectors added with [MainContext.AddDebugCollector] follow. The zip is named after the matrix combination if there is one.
+// Keys and the seed are redacted if enabled, see [WithRedact].
+func (ctx *MainContext) WriteDebugZip() {
+	name := ctx.Now.Format("2006-01-02T15:04:05Z07:00")
+	if ctx.Label != "" {
+		name += "_" + ctx.Label
+	}
+	ctx.debug.l.Lock()
+	collectors := slices.Clone(ctx.debug.collectors)
+	ctx.debug.l.Unlock()
+
+	var files []archive.FileHeader
+	for _, c := range collectors {
+		files = append(files, c(ctx.t)...)
+	}
+	if ctx.redacted {
+		files = redactTree(ctx.redactor(), files)
+	}
+
+	f := errs.Must(os.Create(filepath.Join("test_output", name+".zip")))(ctx.t)
+	defer errs.Defer(ctx.t, f.Close)
+	archive.Archive[archive.Zip](ctx.t, f, files...)
+}
+
+// redactTree redacts the content of every file that is held in memory.
+func redactTree(r *strings.Replacer, files []archive.FileHeader) []archive.FileHeader {
+	files = slices.Clone(files)
+	for i, f := range files {
+		switch f := f.(type) {
+		case archive.Entry[[]byte]:
+			f.Content = []byte(r.Replace(string(f.Content)))
+			files[i] = f
+		case archive.Entry[[]archive.FileHeader]:
+			f.Content = redactTree(r, f.Content)
+			files[i] = f
+		}
+	}
+	return files
+}
+
+// defaultCollectors are the collectors that make up the base of the debug zip.
+func (ctx *MainContext) defaultCollectors() []DebugCollector {
+	return []DebugCollector{
+		func(errs.Testing) []archive.FileHeader {
+			seed := fmt.Sprintf("%s=%d\n", templates.SeedEnv, ctx.Seed)
+			if ctx.redacted {
+				seed = fmt.Sprintf("%s=<redacted>\n", templates.SeedEnv)
+			}
+			return []archive.FileHeader{archive.Entry[[]byte]{Name: SeedName, Time: ctx.Now, Content: []byte(seed)}}
+		},
+		func(t errs.Testing) []archive.FileHeader {
+			return []archive.FileHeader{ctx.Client.debugDir("client"), ctx.Server.debugDir("server")}
+		},
+		ctx.collectTimeline,
+		ctx.collectInspect,
+	}
+}
+
+func (mce *MainContextEntry[D]) debugDir(name string) archive.FileHeader {
+	now := mce.p.Now
+	files := []archive.FileHeader{mce.Caddyfile}
+	if a := caddyconfig.GetAdapter("caddyfile"); a != nil {
+		adapted, _, err := a.Adapt(mce.Caddyfile.Content, nil)
+		var buf bytes.Buffer
+		if err == nil {
+			err = json.Indent(&buf, adapted, "", "  ")
+		}
+		if err != nil {
+			buf.Reset()
+			buf.WriteString(err.Error())
+		}
+		files = append(files, archive.Entry[[]byte]{Name: AdaptedName, Time: now, Content: buf.Bytes()})
+	}
+	return archive.Entry[[]archive.FileHeader]{Name: name, Time: now, Content: append(files,
+		mce.Dockerfile,
+		archive.Entry[[]byte]{Name: BuildLogName, Time: now, Content: mce.BuildLog.Bytes()},
+		archive.Entry[[]byte]{Name: ManifestName, Time: now, Content: errs.Must(json.MarshalIndent(mce.Manifest, "", "  "))(mce.p.t)},
+		archive.Entry[[]byte]{Name: LogName, Time: now, Content: mce.Logs.Bytes()},
+	)}
+}
+
+func (ctx *MainContext) collectTimeline(t errs.Testing) []archive.FileHeader {
+	ctx.debug.l.Lock()
+	timeline := slices.Clone(ctx.debug.timeline)
+	ctx.debug.l.Unlock()
+	now := time.Now()
+	timeline = append(timeline, TimelineEvent{Time: now, Elapsed: now.Sub(ctx.Now).String(), Event: "debug zip written"})
+	return []archive.FileHeader{archive.Entry[[]byte]{Name: TimelineName, Time: ctx.Now, Content: errs.Must(json.MarshalIndent(timeline, "", "  "))(t)}}
+}
+
+// collectInspect inspects every container and network. Errors are written in place of the output, since containers may be gone.
+func (ctx *MainContext) collectInspect(errs.Testing) []archive.FileHeader {
+	if ctx.local {
+		return nil
+	}
+	ctx.debug.l.Lock()
+	networks, containers := slices.Clone(ctx.debug.networks), slices.Clone(ctx.debug.containers)
+	ctx.debug.l.Unlock()
+
+	file := func(name string, b []byte, err error) archive.FileHeader {
+		if err != nil {
+			b = []byte(err.Error())
+		}
+		return archive.Entry[[]byte]{Name: name + ".json", Time: ctx.Now, Content: b}
+	}
+	c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	var cf, nf []archive.FileHeader
+	for _, nc := range containers {
+		b, err := ctx.backend.InspectContainer(c, nc.c)
+		cf = append(cf, file(nc.name, b, err))
+	}
+	for _, n := range networks {
+		b, err := ctx.backend.InspectNetwork(c, n)
+		nf = append(nf, file(n.Name, b, err))
+	}
+	return []archive.FileHeader{archive.Entry[[]archive.FileHeader]{Name: DockerDir, Time: ctx.Now, Content: []archive.FileHeader{
+		archive.Entry[[]archive.FileHeader]{Name: "containers", Time: ctx.Now, Content: cf},
+		archive.Entry[[]archive.FileHeader]{Name: "networks", Time: ctx.Now, Content: nf},
+	}}}
+}
+
+// lockedBuf is a buffer that is safe for concurrent use.
+type lockedBuf struct {
+	b bytes.Buffer
+	l sync.Mutex
+}
+
+// Bytes returns a copy of the buffer's content.
+func (l *lockedBuf) Bytes() []byte {
+	l.l.Lock()
+	defer l.l.Unlock()
+	return slices.Clone(l.b.Bytes())
+}
+
+func (l *lockedBuf) Write(p []byte) (n int, err error) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	return l.b.Write(p)
+}
@@ -0,0 +1,287 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/network"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Fake is an in-memory [Backend]. It does not run anything, but records every call so orchestration can be tested without docker.
+	Fake struct {
+		// Logs produces the log output of a container. No logs are produced if nil.
+		Logs func(testcontainers.ContainerRequest) string
+		// Err is called with every event before it is recorded. If it returns an error the call fails with that error.
+		Err func(event string) error
+
+		l          sync.Mutex
+		events     []string
+		networks   int
+		containers int
+		images     map[string]bool
+		started    map[string]*FakeContainer
+	}
+	// FakeNetwork is the network config that a [Fake] network was created with.
+	FakeNetwork = types.NetworkCreate
+	// FakeContainer is a container made by a [Fake] backend.
+	FakeContainer struct {
+		Name    string
+		Request testcontainers.ContainerRequest
+		f       *Fake
+		// networks are the networks the container is connected to.
+		networks []string
+	}
+)
+
+// Events returns the calls made to the backend, in order.
+// Events are formatted as "<call> <name>", for example "start-container server-0102abcd".
+func (f *Fake) Events() []string {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return append([]string(nil), f.events...)
+}
+
+func (f *Fake) event(call, name string) error {
+	e := call + " " + name
+	if f.Err != nil {
+		if err := f.Err(e); err != nil {
+			return err
+		}
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.events = append(f.events, e)
+	return nil
+}
+
+func (f *Fake) CreateNetwork(_ context.Context, opts ...network.NetworkCustomizer) (Network, error) {
+	var req FakeNetwork
+	for _, opt := range opts {
+		opt.Customize(&req)
+	}
+	f.l.Lock()
+	f.networks++
+	n := Network{ID: fmt.Sprintf("%d", f.networks), Name: fmt.Sprintf("fake-network-%d", f.networks)}
+	f.l.Unlock()
+	if req.Internal {
+		n.Name += "-internal"
+	}
+	return n, f.event("create-network", n.Name)
+}
+
+func (f *Fake) RemoveNetwork(_ context.Context, n Network) error {
+	return f.event("remove-network", n.Name)
+}
+
+func (f *Fake) BuildImage(_ context.Context, tag string, buildContext io.Reader, log io.Writer) error {
+	if _, err := io.Copy(io.Discard, buildContext); err != nil {
+		return err
+	}
+	if err := f.event("build-image", tag); err != nil {
+		return err
+	}
+	f.l.Lock()
+	if f.images == nil {
+		f.images = map[string]bool{}
+	}
+	f.images[tag] = true
+	f.l.Unlock()
+	_, err := fmt.Fprintf(log, "Successfully tagged %s\n", tag)
+	return err
+}
+
+// ImageExists reports if the image was built by this backend.
+func (f *Fake) ImageExists(_ context.Context, tag string) (bool, error) {
+	if err := f.event("image-exists", tag); err != nil {
+		return false, err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	return f.images[tag], nil
+}
+
+// RemoveImage records a "remove-image <tag>" event and forgets the image.
+func (f *Fake) RemoveImage(_ context.Context, tag string) error {
+	if err := f.event("remove-image", tag); err != nil {
+		return err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	delete(f.images, tag)
+	return nil
+}
+
+func (f *Fake) StartContainer(_ context.Context, req testcontainers.ContainerRequest) (Container, error) {
+	c := &FakeContainer{Name: req.Name, Request: req, f: f, networks: slices.Clone(req.Networks)}
+	if c.Name == "" {
+		f.l.Lock()
+		f.containers++
+		c.Name = fmt.Sprintf("fake-container-%d", f.containers)
+		f.l.Unlock()
+	}
+	if err := f.event("start-container", c.Name); err != nil {
+		return nil, err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	if f.started == nil {
+		f.started = map[string]*FakeContainer{}
+	}
+	f.started[c.Name] = c
+	return c, nil
+}
+
+// FindContainer finds containers started by this backend that have not been stopped.
+func (f *Fake) FindContainer(_ context.Context, req testcontainers.ContainerRequest) (Container, map[string]string, error) {
+	if err := f.event("find-container", req.Name); err != nil {
+		return nil, nil, err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	if c, ok := f.started[req.Name]; ok {
+		return c, c.Request.Labels, nil
+	}
+	return nil, nil, nil
+}
+
+// ConnectNetwork records a "connect-network <container> <network>" event if the container is not connected to the network yet.
+func (f *Fake) ConnectNetwork(_ context.Context, c Container, network string) error {
+	fc, err := f.container(c)
+	if err != nil {
+		return err
+	}
+	f.l.Lock()
+	connected := slices.Contains(fc.networks, network)
+	f.l.Unlock()
+	if connected {
+		return nil
+	}
+	if err := f.event("connect-network", fc.Name+" "+network); err != nil {
+		return err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	fc.networks = append(fc.networks, network)
+	return nil
+}
+
+// DisconnectNetwork records a "disconnect-network <container> <network>" event.
+func (f *Fake) DisconnectNetwork(_ context.Context, c Container, network string) error {
+	fc, err := f.container(c)
+	if err != nil {
+		return err
+	}
+	if err := f.event("disconnect-network", fc.Name+" "+network); err != nil {
+		return err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	fc.networks = slices.DeleteFunc(fc.networks, func(n string) bool { return n == network })
+	return nil
+}
+
+func (f *Fake) StopContainer(_ context.Context, c Container, _ *time.Duration) error {
+	fc, err := f.container(c)
+	if err != nil {
+		return err
+	}
+	if err := f.event("stop-container", fc.Name); err != nil {
+		return err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	delete(f.started, fc.Name)
+	return nil
+}
+
+func (f *Fake) ContainerLogs(_ context.Context, c Container, _ time.Time) (io.ReadCloser, error) {
+	fc, err := f.container(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.event("container-logs", fc.Name); err != nil {
+		return nil, err
+	}
+	var logs string
+	if f.Logs != nil {
+		logs = f.Logs(fc.Request)
+	}
+	return io.NopCloser(strings.NewReader(logs)), nil
+}
+
+func (f *Fake) Exec(_ context.Context, c Container, cmd []string) (int, []byte, error) {
+	fc, err := f.container(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	return 0, nil, f.event("exec", fc.Name+" "+strings.Join(cmd, " "))
+}
+
+func (f *Fake) Pause(_ context.Context, c Container) error {
+	return f.containerEvent("pause-container", c)
+}
+
+func (f *Fake) Unpause(_ context.Context, c Container) error {
+	return f.containerEvent("unpause-container", c)
+}
+
+func (f *Fake) Kill(_ context.Context, c Container) error {
+	return f.containerEvent("kill-container", c)
+}
+
+func (f *Fake) Restart(_ context.Context, c Container, _ *time.Duration) error {
+	return f.containerEvent("restart-container", c)
+}
+
+// InspectContainer describes the request the container was made with.
+func (f *Fake) InspectContainer(_ context.Context, c Container) ([]byte, error) {
+	fc, err := f.container(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.event("inspect-container", fc.Name); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]any{"Name": fc.Name, "Image": fc.Request.Image, "Networks": fc.Request.Networks})
+}
+
+func (f *Fake) InspectNetwork(_ context.Context, n Network) ([]byte, error) {
+	if err := f.event("inspect-network", n.Name); err != nil {
+		return nil, err
+	}
+	return json.Marshal(n)
+}
+
+func (f *Fake) containerEvent(call string, c Container) error {
+	fc, err := f.container(c)
+	if err != nil {
+		return err
+	}
+	return f.event(call, fc.Name)
+}
+
+func (f *Fake) container(c Container) (*FakeContainer, error) {
+	if fc, ok := c.(*FakeContainer); ok && fc.f == f {
+		return fc, nil
+	}
+	return nil, fmt.Errorf("container %T was not created by this backend", c)
+}
+
+// MappedPort maps the port to itself.
+func (fc *FakeContainer) MappedPort(_ context.Context, port nat.Port) (nat.Port, error) {
+	for _, p := range fc.Request.ExposedPorts {
+		if p == string(port) {
+			return port, nil
+		}
+	}
+	return "", fmt.Errorf("port %s is not exposed", port)
+}
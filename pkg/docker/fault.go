@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"github.com/point-c/integration/pkg/errs"
+	"github.com/point-c/integration/pkg/local"
+	"time"
+)
+
+// Pause freezes the started container. Traffic to it is dropped until [MainContextEntry.Unpause] is called.
+func (mce *MainContextEntry[D]) Pause() {
+	mce.fault("pausing", mce.p.backend.Pause)
+}
+
+// Unpause resumes a container frozen by [MainContextEntry.Pause].
+func (mce *MainContextEntry[D]) Unpause() {
+	mce.fault("unpausing", mce.p.backend.Unpause)
+}
+
+// Kill sends SIGKILL to the started container. Use [MainContextEntry.Restart] to start it again.
+func (mce *MainContextEntry[D]) Kill() {
+	mce.fault("killing", mce.p.backend.Kill)
+}
+
+// Restart restarts the started container, killing it if it does not stop within 10 seconds.
+// It fails if caddy does not log that the tunnel is up and the config is loaded again within timeout.
+func (mce *MainContextEntry[D]) Restart(timeout time.Duration) {
+	mce.fault("restarting", func(ctx context.Context, c Container) error {
+		offset, since := mce.Logs.Len(), time.Now()
+		to := time.Second * 10
+		if err := mce.p.backend.Restart(ctx, c, &to); err != nil {
+			return err
+		}
+
+		var done <-chan struct{}
+		if i, ok := c.(*local.Instance); ok {
+			done = i.Done()
+		} else {
+			mce.followLogs(since)
+		}
+		ctx, cancel := context.WithTimeout(mce.p, timeout)
+		defer cancel()
+		return waitStartup(ctx, done, &mce.Logs, offset, nil)
+	})
+}
+
+func (mce *MainContextEntry[D]) fault(action string, fn func(context.Context, Container) error) {
+	if mce.container == nil {
+		errs.Check(mce.p.t, errors.New("container must be started before injecting faults"))
+	}
+	if mce.shared() {
+		errs.Check(mce.p.t, errors.New("faults cannot be injected into the shared server, they would affect its other users"))
+	}
+	mce.p.t.Logf("%s %s", action, mce.Config.GetNetworkName())
+	mce.p.Mark("%s %s", action, mce.Config.GetNetworkName())
+	ctx, cancel := context.WithTimeout(mce.p, time.Second*30)
+	defer cancel()
+	errs.Check(mce.p.t, fn(ctx, mce.container))
+}
@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/point-c/integration/pkg/errs"
+	"regexp"
+)
+
+// WithLogGuard fails the run when the client or server logs an entry at error level or above. See [MainContext.CheckLogs].
+// Entries with a line matching any of the allow regular expressions are ignored.
+func WithLogGuard(allow ...string) Option {
+	return func(o *options) {
+		o.logGuard = true
+		for _, a := range allow {
+			o.logAllow = append(o.logAllow, regexp.MustCompile(a))
+		}
+	}
+}
+
+// CheckLogs fails t if the client or server logged an entry at error level or above that is not allowed by [WithLogGuard].
+// Every offending entry is reported along with the name of the container that logged it.
+// When the guard is enabled this is called with the context's testing instance by [MainContext.Cancel].
+func (ctx *MainContext) CheckLogs(t errs.Testing) {
+	t.Helper()
+	var n int
+	for _, e := range []struct {
+		name string
+		logs *LogStream
+	}{
+		{name: ctx.Client.Config.GetNetworkName(), logs: &ctx.Client.Logs},
+		{name: ctx.Server.Config.GetNetworkName(), logs: &ctx.Server.Logs},
+	} {
+		for _, entry := range e.logs.Errors() {
+			if !ctx.allowedLog(entry) {
+				n++
+				t.Errorf("%s logged an unexpected error: %s", e.name, entry)
+			}
+		}
+	}
+	if n > 0 {
+		errs.Check(t, fmt.Errorf("%d unexpected error log entries", n))
+	}
+}
+
+func (ctx *MainContext) allowedLog(e LogEntry) bool {
+	for _, re := range ctx.logAllow {
+		if re.Match(e.Raw) {
+			return true
+		}
+	}
+	return false
+}
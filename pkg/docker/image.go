@@ -0,0 +1,82 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/point-c/integration/pkg/archive"
+	"github.com/point-c/integration/pkg/errs"
+	"io"
+	"os"
+	"time"
+)
+
+// BaseImagePrefix starts the tag of base images, which contain Caddy built with the modules in the manifest.
+const BaseImagePrefix = "caddy-"
+
+// CopiesCaddyfileMarker is a line that marks a Dockerfile override that copies the Caddyfile into the image itself, like the template did before base images were used.
+// Such Dockerfiles are built in one step from a context that contains the Caddyfile.
+const CopiesCaddyfileMarker = "# pointc: copies Caddyfile"
+
+// copiesCaddyfile reports if the Dockerfile contains [CopiesCaddyfileMarker].
+func copiesCaddyfile(dockerfile []byte) bool {
+	for _, l := range bytes.Split(dockerfile, []byte("\n")) {
+		if string(bytes.TrimSpace(l)) == CopiesCaddyfileMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// buildImage builds the base image from the Dockerfile and sources, then the image with the Caddyfile on top of it.
+// Build contexts are deterministic, images that were already built from the same context are reused.
+// Changing the config therefore does not rebuild Caddy, only the Dockerfile and modules manifest do.
+// Dockerfiles with [CopiesCaddyfileMarker] are built in one step from a context with the Caddyfile, and no base image is used.
+func (mce *MainContextEntry[D]) buildImage() {
+	if copiesCaddyfile(mce.Dockerfile.Content) {
+		mce.BaseImage = ""
+		mce.build(append([]archive.FileHeader{mce.Caddyfile, mce.Dockerfile}, mce.Sources...)...)
+		return
+	}
+
+	var base bytes.Buffer
+	m := archive.Deterministic(mce.p.t, mce.p.archiver, &base, append([]archive.FileHeader{mce.Dockerfile}, mce.Sources...)...)
+	mce.BaseImage = ImageRepository + ":" + BaseImagePrefix + m.Digest
+	mce.cachedBuild(mce.BaseImage, &base)
+
+	mce.build(mce.Caddyfile, archive.Entry[[]byte]{
+		Name:    DockerfileName,
+		Content: []byte(fmt.Sprintf("FROM %s\nCOPY %s /etc/caddy/Caddyfile\n", mce.BaseImage, CaddyfileName)),
+	})
+}
+
+// build builds the image of the entry from the files, tagged by the digest of the build context.
+// The image contains the keys of the config, so it is removed by [MainContext.Close]. Base images are kept.
+func (mce *MainContextEntry[D]) build(files ...archive.FileHeader) {
+	var buf bytes.Buffer
+	mce.Manifest = archive.Deterministic(mce.p.t, mce.p.archiver, &buf, files...)
+	image := ImageRepository + ":" + mce.Manifest.Digest
+	mce.Image = image
+	mce.cachedBuild(image, &buf)
+	mce.p.track("image "+image, time.Second*30, mce.shared(), func(c context.Context) error {
+		if err := mce.p.backend.RemoveImage(c, image); err != nil {
+			return err
+		}
+		mce.p.Mark("image %s removed", image)
+		return nil
+	})
+}
+
+// cachedBuild builds the context into tag, unless the image already exists.
+func (mce *MainContextEntry[D]) cachedBuild(tag string, buildContext io.Reader) {
+	c, cn := context.WithTimeout(mce.p, time.Minute*5)
+	defer cn()
+	if errs.Must(mce.p.backend.ImageExists(c, tag))(mce.p.t) {
+		mce.p.Mark("reusing %s", tag)
+		_, _ = fmt.Fprintf(&mce.BuildLog, "build context is unchanged, reusing %s\n", tag)
+		return
+	}
+	mce.p.Mark("building %s", tag)
+	errs.Check(mce.p.t, mce.p.backend.BuildImage(c, tag, buildContext, io.MultiWriter(os.Stderr, &mce.BuildLog)))
+	mce.p.Mark("built %s", tag)
+}
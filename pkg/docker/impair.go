@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/point-c/integration/pkg/errs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LinkProfilesEnv is a comma separated list of link profile names used by [MainContext.LinkProfiles].
+const LinkProfilesEnv = "POINTC_LINK_PROFILES"
+
+// LinkProfile describes the impairment of the link between the client and server.
+// The impairment is applied in each direction, so the round trip delay is twice Delay.
+type LinkProfile struct {
+	Name string
+	// Delay is added to every packet.
+	Delay time.Duration
+	// Jitter is the random variation of Delay.
+	Jitter time.Duration
+	// Loss is the percentage of packets dropped.
+	Loss float64
+	// Reorder is the percentage of packets sent immediately, reordering them with delayed packets. Requires Delay.
+	Reorder float64
+	// Rate limits the bandwidth in bits per second. No limit is applied if 0.
+	Rate uint64
+}
+
+var (
+	// Perfect does not impair the link.
+	Perfect = LinkProfile{Name: "perfect"}
+	// LossyMobile is a congested mobile connection.
+	LossyMobile = LinkProfile{Name: "lossy-mobile", Delay: time.Millisecond * 60, Jitter: time.Millisecond * 30, Loss: 3, Reorder: 1, Rate: 5_000_000}
+	// Satellite is a high latency satellite connection.
+	Satellite = LinkProfile{Name: "satellite", Delay: time.Millisecond * 300, Jitter: time.Millisecond * 20, Loss: 0.5, Rate: 10_000_000}
+	// LinkProfiles are all the predefined link profiles.
+	LinkProfiles = []LinkProfile{Perfect, LossyMobile, Satellite}
+)
+
+// Impaired reports if the profile changes the link at all.
+func (p LinkProfile) Impaired() bool {
+	return p.Delay != 0 || p.Jitter != 0 || p.Loss != 0 || p.Reorder != 0 || p.Rate != 0
+}
+
+// TransferTime estimates how long it takes to transfer size bytes when limited by Rate.
+func (p LinkProfile) TransferTime(size int64) time.Duration {
+	if p.Rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(size*8) / float64(p.Rate) * float64(time.Second))
+}
+
+// netem returns the arguments for `tc qdisc ... netem`.
+func (p LinkProfile) netem() (args []string) {
+	ms := func(d time.Duration) string { return strconv.FormatInt(d.Milliseconds(), 10) + "ms" }
+	pct := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) + "%" }
+	if p.Delay != 0 || p.Jitter != 0 {
+		args = append(args, "delay", ms(p.Delay))
+		if p.Jitter != 0 {
+			args = append(args, ms(p.Jitter))
+		}
+	}
+	if p.Loss != 0 {
+		args = append(args, "loss", pct(p.Loss))
+	}
+	if p.Reorder != 0 {
+		args = append(args, "reorder", pct(p.Reorder))
+	}
+	if p.Rate != 0 {
+		args = append(args, "rate", strconv.FormatUint(p.Rate, 10)+"bit")
+	}
+	return
+}
+
+// impairScript applies netem to the interface used to reach the peer given as $1. The netem arguments follow the peer.
+// The existing qdisc is removed first, so no arguments restores the link.
+const impairScript = `set -e
+addr=$(getent hosts "$1" | awk '{ print $1; exit }')
+dev=$(ip route get "$addr" | sed -n 's/.* dev \([^ ]*\).*/\1/p')
+shift
+tc qdisc del dev "$dev" root 2>/dev/null || true
+[ $# -eq 0 ] || tc qdisc add dev "$dev" root netem "$@"`
+
+// LinkProfiles returns the link profiles that tests should run under.
+// By default all [LinkProfiles] are returned, [LinkProfilesEnv] can be used to select profiles by name.
+// Only [Perfect] is returned when running locally.
+func (ctx *MainContext) LinkProfiles() (p []LinkProfile) {
+	if ctx.local {
+		return []LinkProfile{Perfect}
+	}
+	names, ok := os.LookupEnv(LinkProfilesEnv)
+	if !ok {
+		return slices.Clone(LinkProfiles)
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		i := slices.IndexFunc(LinkProfiles, func(p LinkProfile) bool { return p.Name == name })
+		if i < 0 {
+			errs.Check(ctx.t, fmt.Errorf("unknown link profile %q", name))
+			continue
+		}
+		p = append(p, LinkProfiles[i])
+	}
+	return
+}
+
+// ImpairLink applies the profile to the link between the client and server containers. Both must already be started.
+// In a shared environment only the traffic sent by the client is impaired, since the server is used by other contexts too.
+// Use the returned func to restore the link.
+func (ctx *MainContext) ImpairLink(p LinkProfile) func() {
+	ctx.t.Logf("impairing link with profile %q: %s", p.Name, strings.Join(p.netem(), " "))
+	ctx.impair(p)
+	ctx.Mark("link impaired with profile %q", p.Name)
+	return func() {
+		ctx.t.Logf("restoring link from profile %q", p.Name)
+		ctx.impair(Perfect)
+		ctx.Mark("link restored from profile %q", p.Name)
+	}
+}
+
+func (ctx *MainContext) impair(p LinkProfile) {
+	if ctx.local && !p.Impaired() {
+		return
+	}
+	for _, e := range []struct {
+		c    Container
+		peer string
+	}{
+		{c: ctx.Server.container, peer: ctx.Client.Config.NetworkName},
+		{c: ctx.Client.container, peer: ctx.Client.Config.Endpoint},
+	} {
+		if e.c == nil {
+			errs.Check(ctx.t, errors.New("client and server must be started to impair the link"))
+		}
+		if ctx.Shared() && e.c == ctx.Server.container {
+			continue
+		}
+		c, cn := context.WithTimeout(ctx, time.Second*10)
+		code, out, err := ctx.backend.Exec(c, e.c, append([]string{"sh", "-c", impairScript, "impair", e.peer}, p.netem()...))
+		cn()
+		errs.Check(ctx.t, err)
+		if code != 0 {
+			errs.Check(ctx.t, fmt.Errorf("failed to impair link to %s (exit code %d): %s", e.peer, code, out))
+		}
+	}
+}
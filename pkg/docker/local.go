@@ -0,0 +1,196 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/go-connections/nat"
+	"github.com/point-c/integration/pkg/errs"
+	"github.com/point-c/integration/pkg/local"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/network"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// BackendEnv selects where the client and server are run.
+	BackendEnv = "POINTC_BACKEND"
+	// BackendLocal runs the client and server as child processes of the test binary instead of docker containers. See [local.Main].
+	BackendLocal = "local"
+)
+
+// localBackend is used when running locally. It does not create networks and cannot start containers.
+type localBackend struct{}
+
+func (localBackend) CreateNetwork(context.Context, ...network.NetworkCustomizer) (Network, error) {
+	return Network{Name: BackendLocal}, nil
+}
+
+func (localBackend) RemoveNetwork(context.Context, Network) error { return nil }
+
+func (localBackend) BuildImage(context.Context, string, io.Reader, io.Writer) error {
+	return errors.New("images cannot be built when running locally")
+}
+
+func (localBackend) ImageExists(context.Context, string) (bool, error) {
+	return false, errors.New("images are not used when running locally")
+}
+
+func (localBackend) RemoveImage(context.Context, string) error { return nil }
+
+func (localBackend) FindContainer(context.Context, testcontainers.ContainerRequest) (Container, map[string]string, error) {
+	return nil, nil, errors.New("containers cannot be started when running locally")
+}
+
+func (localBackend) ConnectNetwork(context.Context, Container, string) error { return nil }
+
+func (localBackend) DisconnectNetwork(context.Context, Container, string) error { return nil }
+
+func (localBackend) StartContainer(context.Context, testcontainers.ContainerRequest) (Container, error) {
+	return nil, errors.New("containers cannot be started when running locally")
+}
+
+func (localBackend) StopContainer(ctx context.Context, c Container, timeout *time.Duration) error {
+	i, err := localInstance(c)
+	if err != nil {
+		return err
+	}
+	return i.Stop(ctx, timeout)
+}
+
+func (localBackend) ContainerLogs(context.Context, Container, time.Time) (io.ReadCloser, error) {
+	return nil, errors.New("logs are written directly to the entry when running locally")
+}
+
+func (localBackend) Exec(context.Context, Container, []string) (int, []byte, error) {
+	return 0, nil, errors.New("commands cannot be run when running locally")
+}
+
+func (localBackend) Pause(_ context.Context, c Container) error {
+	i, err := localInstance(c)
+	if err != nil {
+		return err
+	}
+	return i.Pause()
+}
+
+func (localBackend) Unpause(_ context.Context, c Container) error {
+	i, err := localInstance(c)
+	if err != nil {
+		return err
+	}
+	return i.Unpause()
+}
+
+func (localBackend) Kill(_ context.Context, c Container) error {
+	i, err := localInstance(c)
+	if err != nil {
+		return err
+	}
+	return i.Signal(os.Kill)
+}
+
+func (localBackend) Restart(ctx context.Context, c Container, timeout *time.Duration) error {
+	i, err := localInstance(c)
+	if err != nil {
+		return err
+	}
+	return i.Restart(ctx, timeout)
+}
+
+func (localBackend) InspectContainer(context.Context, Container) ([]byte, error) {
+	return nil, errors.New("containers cannot be inspected when running locally")
+}
+
+func (localBackend) InspectNetwork(context.Context, Network) ([]byte, error) {
+	return nil, errors.New("no networks are created when running locally")
+}
+
+func localInstance(c Container) (*local.Instance, error) {
+	if i, ok := c.(*local.Instance); ok {
+		return i, nil
+	}
+	return nil, fmt.Errorf("container %T was not created by this backend", c)
+}
+
+// useLocalPorts replaces the ports and endpoint in the configs so that the client and server can run on the same host.
+func (ctx *MainContext) useLocalPorts() {
+	wg := errs.Must(local.FreePort("udp"))(ctx.t)
+	clientHTTP := errs.Must(local.FreePort("tcp"))(ctx.t)
+	serverForward := errs.Must(local.FreePort("tcp"))(ctx.t)
+
+	ctx.Server.Config.Port = uint16(wg.Int())
+	ctx.Server.Config.ForwardPort = uint16(serverForward.Int())
+	ctx.Client.Config.Endpoint = "127.0.0.1"
+	ctx.Client.Config.EndpointPort = uint16(wg.Int())
+	ctx.Client.Config.HTTPPort = uint16(clientHTTP.Int())
+	ctx.Server.ports = map[nat.Port]nat.Port{"80/tcp": serverForward}
+	ctx.Client.ports = map[nat.Port]nat.Port{"80/tcp": clientHTTP}
+}
+
+// startLocal starts the caddyfile as a child process.
+func (mce *MainContextEntry[D]) startLocal(waitPort ...nat.Port) (Container, func()) {
+	i := errs.Must(local.Start(mce.Config.GetNetworkName(), mce.Caddyfile.Content, mce.ports, &mce.Logs))(mce.p.t)
+	cleanup := mce.p.track("process "+mce.Config.GetNetworkName(), time.Second*20, false, func(c context.Context) error {
+		to := time.Second * 10
+		return mce.p.backend.StopContainer(c, i, &to)
+	})
+
+	panicked := true
+	defer func() {
+		if panicked {
+			defer cleanup()
+		}
+	}()
+
+	var ports []nat.Port
+	for _, p := range waitPort[:min(len(waitPort), 1)] {
+		ports = append(ports, errs.Must(i.MappedPort(mce.p, p))(mce.p.t))
+	}
+	c, cn := context.WithTimeout(mce.p, time.Minute*5)
+	defer cn()
+	errs.Check(mce.p.t, waitStartup(c, i.Done(), &mce.Logs, 0, ports))
+
+	panicked = false
+	mce.container = i
+	return i, cleanup
+}
+
+// waitStartup waits for the startup logs to appear after the first since entries and the ports to be listening.
+// done is closed if the process exits, it may be nil.
+func waitStartup(ctx context.Context, done <-chan struct{}, logs *LogStream, since int, ports []nat.Port) error {
+	tick := time.NewTicker(time.Millisecond * 100)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for caddy to start: %w", ctx.Err())
+		case <-done:
+			if e := logs.Entries(since); len(e) > 0 {
+				return fmt.Errorf("caddy exited before starting, last log: %s", e[len(e)-1].Raw)
+			}
+			return errors.New("caddy exited before starting")
+		case <-tick.C:
+		}
+
+		ready := true
+		for _, f := range startupLogs {
+			ready = ready && len(logs.Filter(since, f)) > 0
+		}
+		for _, p := range ports {
+			if !ready {
+				break
+			}
+			conn, err := net.DialTimeout(p.Proto(), net.JoinHostPort("127.0.0.1", p.Port()), time.Second)
+			if ready = err == nil; ready {
+				_ = conn.Close()
+			}
+		}
+		if ready {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/point-c/integration/pkg/errs"
+	"math"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Log levels written by caddy.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+	LevelPanic = "panic"
+	LevelFatal = "fatal"
+)
+
+// LogEntry is a single line of caddy's JSON log output.
+// Lines that are not JSON only have Msg and Raw set.
+type LogEntry struct {
+	Level  string
+	Time   time.Time
+	Logger string
+	Msg    string
+	// Fields are the remaining keys of the line.
+	Fields map[string]any
+	// Raw is the line as it was written, without the trailing newline.
+	Raw []byte
+}
+
+// IsError reports if the entry was logged at error level or above.
+func (e LogEntry) IsError() bool {
+	return e.Level == LevelError || e.Level == LevelPanic || e.Level == LevelFatal
+}
+
+func (e LogEntry) String() string { return string(e.Raw) }
+
+// parseLogEntry parses a line of zap's JSON output.
+func parseLogEntry(line []byte) LogEntry {
+	e := LogEntry{Raw: slices.Clone(line)}
+	if err := json.Unmarshal(line, &e.Fields); err != nil {
+		e.Msg, e.Fields = string(line), nil
+		return e
+	}
+	take := func(key string) any { v := e.Fields[key]; delete(e.Fields, key); return v }
+	e.Level, _ = take("level").(string)
+	e.Logger, _ = take("logger").(string)
+	e.Msg, _ = take("msg").(string)
+	switch ts := take("ts").(type) {
+	case float64:
+		sec, frac := math.Modf(ts)
+		e.Time = time.Unix(int64(sec), int64(frac*float64(time.Second)))
+	case string:
+		e.Time, _ = time.Parse(time.RFC3339Nano, ts)
+	}
+	return e
+}
+
+// LogFilter selects log entries.
+type LogFilter func(LogEntry) bool
+
+// MatchMsg selects entries with a message matching the regular expression.
+func MatchMsg(pattern string) LogFilter {
+	re := regexp.MustCompile(pattern)
+	return func(e LogEntry) bool { return re.MatchString(e.Msg) }
+}
+
+// MatchField selects entries with the field set to v.
+func MatchField(key string, v any) LogFilter {
+	return func(e LogEntry) bool { f, ok := e.Fields[key]; return ok && f == v }
+}
+
+// MatchAll selects entries that match every filter.
+func MatchAll(filters ...LogFilter) LogFilter {
+	return func(e LogEntry) bool {
+		for _, f := range filters {
+			if !f(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+var (
+	// TunnelUp selects the entry logged when the wireguard interface comes up.
+	TunnelUp = MatchAll(MatchMsg(`^Interface state changed$`), MatchField("Now", "Up"))
+	// ConfigLoaded selects the entry logged when caddy has loaded its config.
+	ConfigLoaded = MatchMsg(`^serving initial configuration$`)
+)
+
+// LogStream parses caddy's log output as it is written.
+type LogStream struct {
+	l       sync.Mutex
+	raw     bytes.Buffer
+	partial []byte
+	entries []LogEntry
+	// changed is closed when an entry is added.
+	changed chan struct{}
+}
+
+func (ls *LogStream) Write(p []byte) (n int, err error) {
+	ls.l.Lock()
+	defer ls.l.Unlock()
+	ls.raw.Write(p)
+	ls.partial = append(ls.partial, p...)
+	added := false
+	for {
+		i := bytes.IndexByte(ls.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimSpace(ls.partial[:i]); len(line) > 0 {
+			ls.entries = append(ls.entries, parseLogEntry(line))
+			added = true
+		}
+		ls.partial = ls.partial[i+1:]
+	}
+	if added && ls.changed != nil {
+		close(ls.changed)
+		ls.changed = nil
+	}
+	return len(p), nil
+}
+
+// Bytes returns everything written to the stream.
+func (ls *LogStream) Bytes() []byte {
+	ls.l.Lock()
+	defer ls.l.Unlock()
+	return slices.Clone(ls.raw.Bytes())
+}
+
+// Len returns the number of entries. It can be passed to [LogStream.Entries] and [LogStream.WaitFor] to only see later entries.
+func (ls *LogStream) Len() int {
+	ls.l.Lock()
+	defer ls.l.Unlock()
+	return len(ls.entries)
+}
+
+// Entries returns the entries after the first since entries.
+func (ls *LogStream) Entries(since int) []LogEntry {
+	ls.l.Lock()
+	defer ls.l.Unlock()
+	return slices.Clone(ls.entries[min(since, len(ls.entries)):])
+}
+
+// Filter returns the entries after the first since entries that match f.
+func (ls *LogStream) Filter(since int, f LogFilter) (matched []LogEntry) {
+	for _, e := range ls.Entries(since) {
+		if f(e) {
+			matched = append(matched, e)
+		}
+	}
+	return
+}
+
+// Errors returns the entries logged at error level or above.
+func (ls *LogStream) Errors() []LogEntry { return ls.Filter(0, LogEntry.IsError) }
+
+// WaitFor waits until an entry after the first since entries matches f, returning that entry.
+func (ls *LogStream) WaitFor(ctx context.Context, since int, f LogFilter) (LogEntry, error) {
+	for {
+		ls.l.Lock()
+		for _, e := range ls.entries[min(since, len(ls.entries)):] {
+			if f(e) {
+				ls.l.Unlock()
+				return e, nil
+			}
+		}
+		since = len(ls.entries)
+		if ls.changed == nil {
+			ls.changed = make(chan struct{})
+		}
+		changed := ls.changed
+		ls.l.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return LogEntry{}, fmt.Errorf("waiting for log entry: %w", ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// RequireNoErrors fails the test if any entry was logged at error level or above.
+func (ls *LogStream) RequireNoErrors(t errs.Testing) {
+	t.Helper()
+	if e := ls.Errors(); len(e) > 0 {
+		errs.Check(t, fmt.Errorf("%d error level log entries, first: %s", len(e), e[0]))
+	}
+}
@@ -0,0 +1,51 @@
+package docker
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLogStream(t *testing.T) {
+	var ls LogStream
+	_, err := ls.Write([]byte(`{"level":"info","ts":1700000000.5,"logger":"point-c","msg":"Interface state changed","Old":"Down","Want":"Up","Now":"Up"}
+not json
+{"level":"error","ts":1700000001,"msg":"handshake fa`))
+	require.NoError(t, err)
+	require.Equal(t, 2, ls.Len(), "partial lines must not be parsed")
+
+	e := ls.Entries(0)
+	require.Equal(t, LogEntry{
+		Level:  LevelInfo,
+		Time:   time.Unix(1700000000, int64(time.Second/2)),
+		Logger: "point-c",
+		Msg:    "Interface state changed",
+		Fields: map[string]any{"Old": "Down", "Want": "Up", "Now": "Up"},
+		Raw:    e[0].Raw,
+	}, e[0])
+	require.Equal(t, LogEntry{Msg: "not json", Raw: []byte("not json")}, e[1])
+	require.Len(t, ls.Filter(0, TunnelUp), 1)
+	require.Empty(t, ls.Errors())
+
+	type result struct {
+		e   LogEntry
+		err error
+	}
+	done := make(chan result)
+	go func(since int) {
+		e, err := ls.WaitFor(context.Background(), since, MatchMsg("^handshake failed$"))
+		done <- result{e: e, err: err}
+	}(ls.Len())
+	_, err = ls.Write([]byte("iled\"}\n"))
+	require.NoError(t, err)
+	res := <-done
+	require.NoError(t, res.err)
+	require.True(t, res.e.IsError())
+	require.Len(t, ls.Errors(), 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ls.WaitFor(ctx, ls.Len(), ConfigLoaded)
+	require.ErrorIs(t, err, context.Canceled)
+}
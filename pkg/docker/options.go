@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"github.com/point-c/integration/pkg/archive"
+	"github.com/point-c/integration/pkg/templates"
+	"os"
+	"regexp"
+)
+
+type (
+	// Option configures a [MainContext].
+	Option  func(*options)
+	options struct {
+		templateDir string
+		combination *templates.Combination
+		local       *bool
+		backend     Backend
+		backendSet  bool
+		redact      bool
+		archiver    archive.Archiver
+		logGuard    bool
+		logAllow    []*regexp.Regexp
+		shared      string
+		family      *templates.Family
+	}
+)
+
+// WithTemplateDir loads template overrides from dir. Templates missing from dir use the embedded defaults.
+// By default the directory in [templates.TemplatesEnv] is used.
+func WithTemplateDir(dir string) Option { return func(o *options) { o.templateDir = dir } }
+
+// WithCombination builds the client and server images from a combination of the module matrix. See [Matrix].
+// By default the top level versions of each manifest are used.
+func WithCombination(c templates.Combination) Option { return func(o *options) { o.combination = &c } }
+
+// WithLocal runs the client and server as child processes of the test binary instead of docker containers.
+// By default this is enabled when [BackendEnv] is set to [BackendLocal] and no backend is given with [WithBackend].
+func WithLocal(local bool) Option { return func(o *options) { o.local = &local } }
+
+// WithBackend sets the backend used to create networks and containers. By default [Testcontainers] is used.
+// The backend is kept when running locally, [BackendEnv] is ignored unless [WithLocal] is also given.
+func WithBackend(b Backend) Option { return func(o *options) { o.backend, o.backendSet = b, true } }
+
+// WithRedact replaces private and preshared keys in debug zips with placeholders. The seed is also left out of the zip, since the keys can be regenerated from it.
+// By default zips are redacted when running in CI, see [RedactEnv].
+func WithRedact(redact bool) Option { return func(o *options) { o.redact = redact } }
+
+// WithContextArchiver sets the format of the docker build context, for example a compressed [archive.TarGz].
+// The docker daemon must support the compression. By default an uncompressed [archive.Tar] is used.
+func WithContextArchiver(a archive.Archiver) Option { return func(o *options) { o.archiver = a } }
+
+// WithShared attaches the context to the shared environment called name, so several test packages can use the same server.
+// The first context to attach picks the seed and starts the server, later ones use the same seed and attach to it.
+// Every context claims one of the [SharedClients] clients of the server and starts it itself, along with its own networks.
+// The server is stopped by [MainContext.Close] of the last attached context. Faults cannot be injected into the shared server.
+// Sharing is disabled when running locally. By default the environment in [SharedEnv] is used, none if it is empty.
+func WithShared(name string) Option { return func(o *options) { o.shared = name } }
+
+// WithFamily sets the address family of the tunnel addresses of the client and server.
+// By default the family in [templates.FamilyEnv] is used, [templates.IPv4] if it is not set.
+func WithFamily(f templates.Family) Option { return func(o *options) { o.family = &f } }
+
+func newOptions(opts []Option) options {
+	o := options{
+		templateDir: os.Getenv(templates.TemplatesEnv),
+		backend:     new(Testcontainers),
+		redact:      redactDefault(),
+		archiver:    archive.Tar{},
+		shared:      os.Getenv(SharedEnv),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.local == nil {
+		local := !o.backendSet && os.Getenv(BackendEnv) == BackendLocal
+		o.local = &local
+	}
+	return o
+}
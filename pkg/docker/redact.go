@@ -0,0 +1,43 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/point-c/integration/pkg/errs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RedactEnv overrides whether debug zips are redacted. It is parsed with [strconv.ParseBool].
+// By default zips are redacted when the CI environment variable is set, which is the case on most CI providers.
+const RedactEnv = "POINTC_REDACT"
+
+// redactDefault reports if debug zips should be redacted when [WithRedact] is not used.
+// An unparsable [RedactEnv] redacts, so that a typo does not leak keys.
+func redactDefault() bool {
+	if v, ok := os.LookupEnv(RedactEnv); ok {
+		b, err := strconv.ParseBool(v)
+		return b || err != nil
+	}
+	_, ci := os.LookupEnv("CI")
+	return ci
+}
+
+// redactor returns a replacer that swaps the private and preshared keys of the configs for stable placeholders.
+// Keys are replaced in both their base64 and hex forms. Public keys are left intact.
+func (ctx *MainContext) redactor() *strings.Replacer {
+	var pairs []string
+	add := func(name string, key interface {
+		MarshalText() ([]byte, error)
+		String() string
+	}) {
+		placeholder := fmt.Sprintf("<redacted %s>", name)
+		pairs = append(pairs, string(errs.Must(key.MarshalText())(ctx.t)), placeholder, key.String(), placeholder)
+	}
+	add("server private key", ctx.Server.Config.Private)
+	add("client private key", ctx.Client.Config.Private)
+	for _, p := range ctx.Server.Config.Peers {
+		add(p.NetworkName+" preshared key", p.Shared)
+	}
+	return strings.NewReplacer(pairs...)
+}
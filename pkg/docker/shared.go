@@ -0,0 +1,124 @@
+package docker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// SharedEnv names the shared environment test packages attach to, see [WithShared].
+const SharedEnv = "POINTC_SHARED"
+
+// SharedClients is how many contexts can attach to a shared environment at the same time. The shared server has a peer for each of them.
+const SharedClients = 8
+
+const (
+	// LabelEnv is the shared environment a container belongs to.
+	LabelEnv = ImageRepository + ".env"
+	// LabelDigest is the digest of the build context of the server in a shared environment.
+	LabelDigest = ImageRepository + ".digest"
+)
+
+type (
+	// shared is the attachment of a context to a shared environment.
+	shared struct {
+		name string
+		// id identifies the context, a process may attach more than once.
+		id string
+	}
+	// sharedState is kept in the lockfile of a shared environment.
+	sharedState struct {
+		// Seed generates the configs of every user, so they all get the same server.
+		Seed  int64        `json:"seed"`
+		Users []sharedUser `json:"users"`
+	}
+	sharedUser struct {
+		PID int    `json:"pid"`
+		ID  string `json:"id"`
+		// Slot is the client of the topology used by the user.
+		Slot int `json:"slot"`
+	}
+)
+
+func newShared(name string) *shared {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return &shared{name: name, id: hex.EncodeToString(id[:])}
+}
+
+// path is the lockfile of the environment. It is shared by every process on the host.
+func (s *shared) path() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("%s-%s.json", ImageRepository, s.name))
+}
+
+// update locks the environment and calls fn with its state, saving the state afterwards.
+// Users whose process has exited are removed before fn is called. The lock is held while fn runs, so fn can safely find or create shared resources.
+func (s *shared) update(fn func(*sharedState)) (err error) {
+	f, err := os.OpenFile(s.path(), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	// Closing the file releases the lock
+	defer func() { err = errors.Join(err, f.Close()) }()
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("locking shared environment %q: %w", s.name, err)
+	}
+
+	var st sharedState
+	if b, err := io.ReadAll(f); err != nil {
+		return err
+	} else if len(b) > 0 {
+		if err := json.Unmarshal(b, &st); err != nil {
+			return fmt.Errorf("reading shared environment %q: %w", s.name, err)
+		}
+	}
+	st.Users = slices.DeleteFunc(st.Users, func(u sharedUser) bool { return !processAlive(u.PID) })
+	fn(&st)
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(b, 0)
+	return err
+}
+
+// attach adds the context to the users of the environment, giving it the first client slot that is not used.
+// The seed of the environment is returned, or seed if the context is its first user.
+func (s *shared) attach(seed int64) (_ int64, slot int, err error) {
+	err = s.update(func(st *sharedState) {
+		if len(st.Users) == 0 {
+			st.Seed = seed
+		}
+		for slices.ContainsFunc(st.Users, func(u sharedUser) bool { return u.Slot == slot }) {
+			slot++
+		}
+		if slot >= SharedClients {
+			return
+		}
+		st.Users = append(st.Users, sharedUser{PID: os.Getpid(), ID: s.id, Slot: slot})
+		seed = st.Seed
+	})
+	if err == nil && slot >= SharedClients {
+		err = fmt.Errorf("shared environment %q already has %d users", s.name, SharedClients)
+	}
+	return seed, slot, err
+}
+
+// detach removes the context from the users of the environment, calling fn with the lock held.
+// last reports if no other context is attached, so shared resources should be removed.
+func (s *shared) detach(fn func(last bool)) error {
+	return s.update(func(st *sharedState) {
+		st.Users = slices.DeleteFunc(st.Users, func(u sharedUser) bool { return u.ID == s.id })
+		fn(len(st.Users) == 0)
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package docker
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// lockFile fails, shared environments are only supported on unix platforms.
+func lockFile(*os.File) error {
+	return fmt.Errorf("shared environments are not supported on %s", runtime.GOOS)
+}
+
+// processAlive assumes the process is alive, since it cannot be checked.
+func processAlive(int) bool { return true }
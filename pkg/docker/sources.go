@@ -0,0 +1,46 @@
+package docker
+
+import (
+	"errors"
+	"github.com/point-c/integration/pkg/archive"
+	"github.com/point-c/integration/pkg/errs"
+	"github.com/point-c/integration/pkg/templates"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// SourceEntries reads the local source trees used by the Dockerfile so they can be added to the build context.
+func SourceEntries(t errs.Testing, now time.Time, dd templates.DotDockerfile) []archive.FileHeader {
+	t.Helper()
+	var src []archive.FileHeader
+	for _, s := range dd.Sources() {
+		t.Logf("adding source of %s from %s to build context", s.Module, s.Path)
+		src = append(src, sourceTree(t, s.Path, path.Base(s.Context)))
+	}
+	if len(src) == 0 {
+		return nil
+	}
+	return []archive.FileHeader{archive.Entry[[]archive.FileHeader]{Name: templates.SourcesDir, Time: now, Content: src}}
+}
+
+// sourceTree reads a directory on the host into an archive entry.
+// Version control folders are skipped, along with anything matched by a .dockerignore file in the directory.
+func sourceTree(t errs.Testing, dir, name string) archive.FileHeader {
+	t.Helper()
+	exclude := []string{".git"}
+	if b, err := os.ReadFile(filepath.Join(dir, ".dockerignore")); err == nil {
+		exclude = append(exclude, archive.IgnorePatterns(b)...)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		errs.Check(t, err)
+	}
+	info := errs.Must(os.Stat(dir))(t)
+	return archive.Entry[[]archive.FileHeader]{
+		Name:    name,
+		Time:    info.ModTime(),
+		Mode:    info.Mode().Perm(),
+		Content: archive.Dir(t, dir, archive.Exclude(exclude...)),
+	}
+}
@@ -0,0 +1,55 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/point-c/integration/pkg/archive"
+	"github.com/point-c/integration/pkg/templates"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// logRecorder records log messages.
+type logRecorder struct {
+	*testing.T
+	logs []string
+}
+
+func (l *logRecorder) Logf(s string, a ...any) { l.logs = append(l.logs, fmt.Sprintf(s, a...)) }
+
+func TestSourceEntries(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "caddy")
+	for name, content := range map[string]string{
+		"go.mod":           "module github.com/point-c/caddy",
+		"module/module.go": "package module",
+		".git/HEAD":        "ref: refs/heads/main",
+		"ignored.txt":      "ignored",
+		".dockerignore":    "ignored.txt\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	require.NoError(t, os.Symlink("go.mod", filepath.Join(dir, "link")))
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	l := &logRecorder{T: t}
+	entries := SourceEntries(l, now, templates.DotDockerfile{Mods: []string{"github.com/point-c/caddy/module", "github.com/point-c/caddy=" + dir}})
+	require.Len(t, entries, 1)
+	src := entries[0].(archive.Entry[[]archive.FileHeader])
+	require.Equal(t, templates.SourcesDir, src.Name)
+	require.Equal(t, now, src.Time)
+	require.Len(t, src.Content, 1)
+	tree := src.Content[0].(archive.Entry[[]archive.FileHeader])
+	require.Equal(t, "1-caddy", tree.Name, "the tree must be at the path used by the Dockerfile")
+
+	var names []string
+	for _, f := range tree.Content {
+		names = append(names, f.EntryName())
+	}
+	require.Equal(t, []string{".dockerignore", "go.mod", "module"}, names, "version control folders and ignored files must be left out")
+	require.Contains(t, l.logs, "skipping link, only regular files and folders are read")
+
+	require.Nil(t, SourceEntries(t, now, templates.DotDockerfile{Mods: []string{"github.com/point-c/caddy/module"}}))
+}
@@ -0,0 +1,67 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Collector records errors instead of stopping at the first one, so every failure can be reported together.
+// It implements [Testing], which makes checks against it soft assertions: [Check] and [Must] record the error and execution continues.
+// A failed [Must] still returns the value it was given. A Collector is safe for concurrent use.
+type Collector struct {
+	t    Testing
+	l    sync.Mutex
+	errs []error
+}
+
+// NewCollector creates a collector that reports to t.
+func NewCollector(t Testing) *Collector { return &Collector{t: t} }
+
+// Add records err if it is not nil. It reports if err was nil.
+func (c *Collector) Add(err error) bool {
+	if err == nil {
+		return true
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.errs = append(c.errs, err)
+	return false
+}
+
+// Defer records the error returned by fn, for use with defer.
+func (c *Collector) Defer(fn func() error) { c.Add(fn()) }
+
+// Err joins every error recorded since the last [Collector.Report] with [errors.Join]. It is nil if there are none.
+func (c *Collector) Err() error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return errors.Join(c.errs...)
+}
+
+// Report fails the test with every error recorded since the last report, then stops it if there were any.
+func (c *Collector) Report() {
+	c.t.Helper()
+	c.l.Lock()
+	e := c.errs
+	c.errs = nil
+	c.l.Unlock()
+	for _, err := range e {
+		c.t.Errorf("%v", err)
+	}
+	if len(e) > 0 {
+		c.t.FailNow()
+	}
+}
+
+// Helper marks the caller as a helper of the reporting test.
+func (c *Collector) Helper() { c.t.Helper() }
+
+// Logf logs to the reporting test.
+func (c *Collector) Logf(s string, a ...any) { c.t.Logf(s, a...) }
+
+// Errorf records an error.
+func (c *Collector) Errorf(s string, a ...any) { c.Add(fmt.Errorf(s, a...)) }
+
+// FailNow does nothing, so execution continues after a failed check.
+func (c *Collector) FailNow() {}
@@ -0,0 +1,29 @@
+package errs
+
+import (
+	"errors"
+	"github.com/point-c/integration/pkg/errs/errstest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCollector(t *testing.T) {
+	r := errstest.NewRecorder(t)
+	c := NewCollector(r)
+	errA, errB := errors.New("a"), errors.New("b")
+
+	require.True(t, c.Add(nil))
+	require.NoError(t, c.Err())
+	require.False(t, c.Add(errA))
+	Must(1, errB)(c)
+	c.Defer(func() error { return nil })
+	require.ErrorIs(t, c.Err(), errA)
+	require.EqualError(t, c.Err(), "a\nb")
+
+	require.True(t, r.Failed(c.Report))
+	require.Equal(t, []string{"a", "b"}, r.Errs())
+
+	require.False(t, r.Failed(c.Report), "errors are only reported once")
+	require.Len(t, r.Errs(), 2)
+	require.NoError(t, c.Err())
+}
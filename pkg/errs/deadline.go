@@ -0,0 +1,16 @@
+package errs
+
+import "time"
+
+// DefaultTimeout is used as the deadline of tests that have none.
+const DefaultTimeout = time.Minute * 5
+
+// Deadline gets the deadline that is passed to testing. If it is not set it returns the current time added to [DefaultTimeout].
+func Deadline(t Testing) time.Time {
+	if t, ok := t.(interface{ Deadline() (time.Time, bool) }); ok {
+		if d, ok := t.Deadline(); ok {
+			return d
+		}
+	}
+	return time.Now().Add(DefaultTimeout)
+}
@@ -0,0 +1,54 @@
+// Package errstest helps testing helpers that report failures through a testing.TB.
+package errstest
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Recorder is a testing.TB that records failures instead of failing the test.
+// FailNow panics to stop the helper, use [Recorder.Failed] to run it.
+type Recorder struct {
+	testing.TB
+	l    sync.Mutex
+	errs []string
+}
+
+// failNow is the panic value of [Recorder.FailNow].
+type failNow struct{}
+
+// NewRecorder creates a recorder that logs to t.
+func NewRecorder(t testing.TB) *Recorder { return &Recorder{TB: t} }
+
+// Errorf records the formatted failure.
+func (r *Recorder) Errorf(s string, a ...any) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.errs = append(r.errs, fmt.Sprintf(s, a...))
+}
+
+// FailNow stops the helper, see [Recorder.Failed].
+func (r *Recorder) FailNow() { panic(failNow{}) }
+
+// Failed runs fn and reports if it called FailNow. Other panics are not recovered.
+func (r *Recorder) Failed(fn func()) (failed bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			if _, ok := v.(failNow); !ok {
+				panic(v)
+			}
+			failed = true
+		}
+	}()
+	fn()
+	return
+}
+
+// Errs returns the recorded failures.
+func (r *Recorder) Errs() []string {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return slices.Clone(r.errs)
+}
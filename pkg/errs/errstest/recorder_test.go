@@ -0,0 +1,14 @@
+package errstest
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder(t)
+	require.False(t, r.Failed(func() { r.Logf("not a failure") }))
+	require.True(t, r.Failed(func() { r.Errorf("a %d", 1); r.FailNow() }))
+	require.Equal(t, []string{"a 1"}, r.Errs())
+	require.PanicsWithValue(t, "other", func() { r.Failed(func() { panic("other") }) }, "other panics must not be recovered")
+}
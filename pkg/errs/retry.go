@@ -0,0 +1,82 @@
+package errs
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type (
+	// RetryOption configures [Retry] and [Eventually].
+	RetryOption  func(*retryOptions)
+	retryOptions struct {
+		ctx            context.Context
+		timeout        time.Duration
+		attemptTimeout time.Duration
+		initial, max   time.Duration
+	}
+)
+
+// WithContext stops retrying when ctx is done. Attempts are passed a context derived from ctx.
+func WithContext(ctx context.Context) RetryOption {
+	return func(o *retryOptions) { o.ctx = ctx }
+}
+
+// WithTimeout stops retrying after d. By default attempts are retried until the deadline of the test, see [Deadline].
+func WithTimeout(d time.Duration) RetryOption {
+	return func(o *retryOptions) { o.timeout = d }
+}
+
+// WithAttemptTimeout cancels the context of each attempt after d.
+func WithAttemptTimeout(d time.Duration) RetryOption {
+	return func(o *retryOptions) { o.attemptTimeout = d }
+}
+
+// WithBackoff waits initial after the first failed attempt, doubling the wait after every attempt up to max.
+// The default is to start at 100ms and wait at most 5s.
+func WithBackoff(initial, max time.Duration) RetryOption {
+	return func(o *retryOptions) { o.initial, o.max = initial, max }
+}
+
+// Retry calls fn until it succeeds, see [Eventually].
+func Retry(t Testing, fn func(context.Context) error, opts ...RetryOption) {
+	t.Helper()
+	Eventually(t, func(ctx context.Context) (struct{}, error) { return struct{}{}, fn(ctx) }, opts...)
+}
+
+// Eventually calls fn until it succeeds, returning its value. Failed attempts are logged and retried with exponential backoff.
+// If fn has not succeeded by the deadline the test fails with the last error.
+func Eventually[T any](t Testing, fn func(context.Context) (T, error), opts ...RetryOption) T {
+	t.Helper()
+	o := retryOptions{ctx: context.Background(), initial: time.Millisecond * 100, max: time.Second * 5}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	deadline := Deadline(t)
+	if o.timeout > 0 {
+		deadline = time.Now().Add(o.timeout)
+	}
+	ctx, cancel := context.WithDeadline(o.ctx, deadline)
+	defer cancel()
+
+	wait := o.initial
+	for attempt := 1; ; attempt++ {
+		ac, acn := ctx, context.CancelFunc(func() {})
+		if o.attemptTimeout > 0 {
+			ac, acn = context.WithTimeout(ctx, o.attemptTimeout)
+		}
+		v, err := fn(ac)
+		acn()
+		if err == nil {
+			return v
+		}
+		t.Logf("attempt %d failed: %v", attempt, err)
+		select {
+		case <-ctx.Done():
+			Check(t, fmt.Errorf("gave up after %d attempts: %w", attempt, err))
+			return v
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, o.max)
+	}
+}
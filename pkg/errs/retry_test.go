@@ -0,0 +1,42 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"github.com/point-c/integration/pkg/errs/errstest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestEventually(t *testing.T) {
+	var attempts int
+	v := Eventually(t, func(context.Context) (int, error) {
+		if attempts++; attempts < 3 {
+			return 0, errors.New("not yet")
+		}
+		return attempts, nil
+	}, WithBackoff(time.Millisecond, time.Millisecond*2))
+	require.Equal(t, 3, v)
+
+	r := errstest.NewRecorder(t)
+	errFail := errors.New("never")
+	start := time.Now()
+	require.True(t, r.Failed(func() {
+		Retry(r, func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			require.True(t, ok, "attempts must have a deadline")
+			return errFail
+		}, WithTimeout(time.Millisecond*50), WithAttemptTimeout(time.Second), WithBackoff(time.Millisecond, time.Millisecond*10))
+	}))
+	require.Less(t, time.Since(start), time.Second)
+	require.Len(t, r.Errs(), 1)
+	require.Contains(t, r.Errs()[0], "never")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = errstest.NewRecorder(t)
+	require.True(t, r.Failed(func() {
+		Retry(r, func(context.Context) error { return errFail }, WithContext(ctx))
+	}), "retrying must stop when the context is done")
+}
@@ -1,10 +1,13 @@
 package errs
 
 import (
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/require"
 	"log/slog"
 	"os"
+	"runtime/debug"
+	"sync"
 	"testing"
 )
 
@@ -18,25 +21,87 @@ type (
 	// TestMain is a wrapper to help with testing a TestMain function.
 	TestMain struct {
 		*testing.M
-		code int
-		ok   bool
+		code     int
+		ok       bool
+		l        sync.Mutex
+		failed   bool
+		cleanups []func()
 	}
+	// FailNowError is the panic value of [TestMain.FailNow]. [TestMain.Exit] recovers it, the failure was already reported with Errorf.
+	FailNowError struct{}
 )
 
+func (FailNowError) Error() string { return "FailNow called" }
+
 // NewTestMain creates a new TestMain wrapping [testing.M].
 func NewTestMain(m *testing.M) *TestMain { return &TestMain{M: m} }
+
+// Exit runs the cleanups and exits with the result of the tests. It must be deferred directly, so it can recover a failure or panic.
+// Panics other than [FailNowError] are logged along with their stack trace. The exit code is 1 if anything failed or [TestMain.Run] was never reached.
 func (t *TestMain) Exit() {
-	if !t.ok {
-		t.Logf("caught panic: %v", recover())
-		if t.code == 0 {
-			t.code = 1
+	os.Exit(t.exitCode(recover()))
+}
+
+// exitCode runs the cleanups and finds the exit code, given the value recovered by Exit.
+func (t *TestMain) exitCode(r any) int {
+	t.recovered(r, debug.Stack())
+	t.runCleanups()
+	t.l.Lock()
+	defer t.l.Unlock()
+	if (t.failed || !t.ok) && t.code == 0 {
+		t.code = 1
+	}
+	return t.code
+}
+
+// recovered reports a recovered panic.
+func (t *TestMain) recovered(r any, stack []byte) {
+	if r == nil {
+		return
+	}
+	if err, ok := r.(error); ok && errors.Is(err, FailNowError{}) {
+		t.fail()
+		return
+	}
+	t.Errorf("panic: %v\n%s", r, stack)
+}
+
+// Cleanup registers fn to be run by [TestMain.Exit]. Cleanups are run in the reverse order they were added, like [testing.T.Cleanup].
+// A cleanup that fails or panics does not stop the others from running.
+func (t *TestMain) Cleanup(fn func()) {
+	t.l.Lock()
+	defer t.l.Unlock()
+	t.cleanups = append(t.cleanups, fn)
+}
+
+func (t *TestMain) runCleanups() {
+	for {
+		t.l.Lock()
+		if len(t.cleanups) == 0 {
+			t.l.Unlock()
+			return
 		}
+		fn := t.cleanups[len(t.cleanups)-1]
+		t.cleanups = t.cleanups[:len(t.cleanups)-1]
+		t.l.Unlock()
+		func() {
+			defer func() { t.recovered(recover(), debug.Stack()) }()
+			fn()
+		}()
 	}
-	os.Exit(t.code)
 }
 
 // Run runs the tests saving the return code for exiting later.
-func (t *TestMain) Run() { t.code = t.M.Run(); t.ok = true }
+// Run may be called multiple times, a failure in any run is kept as the return code.
+func (t *TestMain) Run() {
+	code := t.M.Run()
+	t.l.Lock()
+	defer t.l.Unlock()
+	if code != 0 || t.code == 0 {
+		t.code = code
+	}
+	t.ok = true
+}
 
 // Helper is a noop.
 func (t *TestMain) Helper() {}
@@ -44,8 +109,20 @@ func (t *TestMain) Helper() {}
 // Logf logs to [slog.Info].
 func (t *TestMain) Logf(s string, a ...any) { slog.Info(fmt.Sprintf(s, a...)) }
 
-// Errorf logs to [slog.Error].
-func (t *TestMain) Errorf(s string, a ...interface{}) { slog.Error(fmt.Sprintf(s, a...)) }
+// Errorf logs to [slog.Error] and marks the run as failed.
+func (t *TestMain) Errorf(s string, a ...interface{}) {
+	t.fail()
+	slog.Error(fmt.Sprintf(s, a...))
+}
+
+// FailNow marks the run as failed and panics with [FailNowError], stopping the setup.
+func (t *TestMain) FailNow() {
+	t.fail()
+	panic(FailNowError{})
+}
 
-// FailNow causes a nil panic.
-func (t *TestMain) FailNow() { panic(nil) }
+func (t *TestMain) fail() {
+	t.l.Lock()
+	defer t.l.Unlock()
+	t.failed = true
+}
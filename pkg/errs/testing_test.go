@@ -0,0 +1,23 @@
+package errs
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTestMain(t *testing.T) {
+	var order []int
+	tm := &TestMain{ok: true}
+	tm.Cleanup(func() { order = append(order, 1) })
+	tm.Cleanup(func() { order = append(order, 2); panic("cleanup") })
+	tm.Cleanup(func() { order = append(order, 3); tm.FailNow() })
+	require.Equal(t, 1, tm.exitCode(nil))
+	require.Equal(t, []int{3, 2, 1}, order, "cleanups run in reverse order, even if one fails")
+
+	tm = &TestMain{ok: true}
+	require.Equal(t, 0, tm.exitCode(nil))
+	require.Equal(t, 1, (&TestMain{ok: true}).exitCode(FailNowError{}))
+	require.Equal(t, 1, (&TestMain{ok: true}).exitCode("setup panicked"))
+	require.Equal(t, 1, (&TestMain{}).exitCode(nil), "tests that never ran must fail")
+	require.Panics(t, func() { new(TestMain).FailNow() })
+}
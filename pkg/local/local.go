@@ -0,0 +1,238 @@
+// Package local runs caddy instances as child processes of the test binary instead of docker containers.
+// The test binary must import the caddyfile adapter and any modules used by the caddyfile, and call [Main] at the start of TestMain.
+//
+// Caddy only runs one config per process: [caddy.Load] replaces the running config and its apps, modules and loggers are process wide.
+// The client and server therefore cannot run in the test process at the same time, so each one runs in its own copy of the test binary.
+// This keeps them as isolated as containers, and lets a fault kill or pause one of them without affecting the tests.
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/docker/go-connections/nat"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CaddyfileEnv is set on the child process to the path of the caddyfile it should run.
+const CaddyfileEnv = "POINTC_LOCAL_CADDYFILE"
+
+// Main runs caddy if the test binary was started by [Start], exiting when caddy is stopped. Otherwise it returns immediately.
+func Main() {
+	p, ok := os.LookupEnv(CaddyfileEnv)
+	if !ok {
+		return
+	}
+	if err := run(p); err != nil {
+		slog.Error("failed to run caddy", "caddyfile", p, "err", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// checkToolchain fails if caddy cannot provision modules when built by the current toolchain.
+// Caddy 2.7 finds module maps by the name of json.RawMessage, which is an alias of jsontext.Value with the json v2 experiment.
+func checkToolchain() error {
+	if t := reflect.TypeOf(json.RawMessage{}); t.PkgPath() != "encoding/json" || t.Name() != "RawMessage" {
+		return fmt.Errorf("caddy cannot load modules when json.RawMessage is %s.%s, build the tests with GOEXPERIMENT=nojsonv2", t.PkgPath(), t.Name())
+	}
+	return nil
+}
+
+func run(path string) error {
+	if err := checkToolchain(); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	adapter := caddyconfig.GetAdapter("caddyfile")
+	if adapter == nil {
+		return errors.New("caddyfile adapter is not registered, import github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile")
+	}
+	b, _, err = adapter.Adapt(b, nil)
+	if err != nil {
+		return err
+	}
+
+	// Both instances run on the same host so the admin endpoint is disabled to prevent them from conflicting.
+	var cfg map[string]any
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return err
+	}
+	cfg["admin"] = map[string]any{"disabled": true}
+	if b, err = json.Marshal(cfg); err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	if err := caddy.Load(b, true); err != nil {
+		return err
+	}
+	// Logged to match the output of `caddy run`
+	caddy.Log().Info("serving initial configuration")
+	<-sig
+	return caddy.Stop()
+}
+
+// Instance is a caddy instance running in a child process.
+type Instance struct {
+	l     sync.Mutex
+	cmd   *exec.Cmd
+	dir   string
+	ports map[nat.Port]nat.Port
+	logs  io.Writer
+	done  chan struct{}
+}
+
+// Start runs the caddyfile in a new child process. Output from the process is written to logs.
+// ports maps the ports the caddyfile would expose in a container to the ports it listens on in the host.
+func Start(name string, caddyfile []byte, ports map[nat.Port]nat.Port, logs io.Writer) (*Instance, error) {
+	if err := checkToolchain(); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "point-c-"+name+"-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Caddyfile"), caddyfile, os.ModePerm); err != nil {
+		return nil, errors.Join(err, os.RemoveAll(dir))
+	}
+
+	i := &Instance{dir: dir, ports: ports, logs: logs}
+	if err := i.start(); err != nil {
+		return nil, errors.Join(err, os.RemoveAll(dir))
+	}
+	return i, nil
+}
+
+func (i *Instance) start() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, "-test.run=^$")
+	cmd.Dir = i.dir
+	cmd.Env = append(os.Environ(), CaddyfileEnv+"="+filepath.Join(i.dir, "Caddyfile"))
+	cmd.Stdout, cmd.Stderr = i.logs, i.logs
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() { defer close(done); _ = cmd.Wait() }()
+	i.l.Lock()
+	defer i.l.Unlock()
+	i.cmd, i.done = cmd, done
+	return nil
+}
+
+func (i *Instance) process() (*exec.Cmd, chan struct{}) {
+	i.l.Lock()
+	defer i.l.Unlock()
+	return i.cmd, i.done
+}
+
+// MappedPort returns the port on the host for the given port.
+func (i *Instance) MappedPort(_ context.Context, port nat.Port) (nat.Port, error) {
+	if p, ok := i.ports[port]; ok {
+		return p, nil
+	}
+	return "", fmt.Errorf("port %s is not mapped", port)
+}
+
+// Done is closed when the current process exits.
+func (i *Instance) Done() <-chan struct{} {
+	_, done := i.process()
+	return done
+}
+
+// Signal sends sig to the current process. Nothing is done if the process has exited.
+func (i *Instance) Signal(sig os.Signal) error {
+	cmd, done := i.process()
+	select {
+	case <-done:
+		return nil
+	default:
+	}
+	return cmd.Process.Signal(sig)
+}
+
+// Restart stops the current process if it is running, then runs the caddyfile in a new process.
+func (i *Instance) Restart(ctx context.Context, timeout *time.Duration) error {
+	if err := i.stop(ctx, timeout); err != nil {
+		return err
+	}
+	return i.start()
+}
+
+// Stop stops the instance. The process is killed if it does not stop before timeout.
+func (i *Instance) Stop(ctx context.Context, timeout *time.Duration) error {
+	defer os.RemoveAll(i.dir)
+	return i.stop(ctx, timeout)
+}
+
+func (i *Instance) stop(ctx context.Context, timeout *time.Duration) error {
+	cmd, done := i.process()
+	select {
+	case <-done:
+		return nil
+	default:
+	}
+
+	// A paused process cannot handle SIGTERM
+	_ = i.Unpause()
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+	var to <-chan time.Time
+	if timeout != nil {
+		to = time.After(*timeout)
+	}
+	select {
+	case <-done:
+		return nil
+	case <-to:
+	case <-ctx.Done():
+	}
+	if err := cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// FreePort finds an unused port on the host for the given protocol.
+func FreePort(proto string) (nat.Port, error) {
+	switch proto {
+	case "udp":
+		c, err := net.ListenPacket("udp", ":0")
+		if err != nil {
+			return "", err
+		}
+		defer c.Close()
+		return nat.NewPort(proto, fmt.Sprintf("%d", c.LocalAddr().(*net.UDPAddr).Port))
+	default:
+		ln, err := net.Listen("tcp", ":0")
+		if err != nil {
+			return "", err
+		}
+		defer ln.Close()
+		return nat.NewPort(proto, fmt.Sprintf("%d", ln.Addr().(*net.TCPAddr).Port))
+	}
+}
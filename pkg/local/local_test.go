@@ -0,0 +1,76 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	_ "github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	_ "github.com/caddyserver/caddy/v2/modules/standard"
+	"github.com/docker/go-connections/nat"
+	"github.com/point-c/integration/pkg/errs"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	Main()
+	os.Exit(m.Run())
+}
+
+// syncBuffer is written by the process output goroutines while the test reads it.
+type syncBuffer struct {
+	l sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.b.String()
+}
+
+func TestStart(t *testing.T) {
+	if err := checkToolchain(); err != nil {
+		t.Skip(err)
+	}
+	port := errs.Must(FreePort("tcp"))(t)
+	caddyfile := fmt.Sprintf("{\n\tauto_https off\n}\n\nhttp://127.0.0.1:%s {\n\trespond \"ok\"\n}\n", port.Port())
+	var logs syncBuffer
+	i := errs.Must(Start("test", []byte(caddyfile), map[nat.Port]nat.Port{"80/tcp": port}, &logs))(t)
+	to := time.Second * 10
+	defer func() { require.NoError(t, i.Stop(context.Background(), &to)) }()
+
+	mapped := errs.Must(i.MappedPort(context.Background(), "80/tcp"))(t)
+	get := func(ctx context.Context) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:"+mapped.Port(), nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+	opts := []errs.RetryOption{errs.WithTimeout(time.Second * 30), errs.WithBackoff(time.Millisecond*50, time.Second)}
+	require.Equal(t, "ok", errs.Eventually(t, get, opts...))
+	require.Contains(t, logs.String(), "serving initial configuration")
+
+	require.NoError(t, i.Restart(context.Background(), &to))
+	require.Equal(t, "ok", errs.Eventually(t, get, opts...), "caddy must serve again after a restart")
+	require.Equal(t, 2, strings.Count(logs.String(), "serving initial configuration"))
+}
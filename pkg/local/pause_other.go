@@ -0,0 +1,17 @@
+//go:build !unix
+
+package local
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// errPause is returned by [Instance.Pause] and [Instance.Unpause] on platforms without SIGSTOP.
+var errPause = fmt.Errorf("pausing processes is not supported on %s", runtime.GOOS)
+
+// Pause is not supported on this platform.
+func (i *Instance) Pause() error { return errPause }
+
+// Unpause is not supported on this platform.
+func (i *Instance) Unpause() error { return errPause }
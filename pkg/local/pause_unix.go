@@ -0,0 +1,11 @@
+//go:build unix
+
+package local
+
+import "syscall"
+
+// Pause freezes the current process with SIGSTOP.
+func (i *Instance) Pause() error { return i.Signal(syscall.SIGSTOP) }
+
+// Unpause resumes a process frozen by [Instance.Pause].
+func (i *Instance) Unpause() error { return i.Signal(syscall.SIGCONT) }
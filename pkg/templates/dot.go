@@ -4,45 +4,83 @@ package templates
 import (
 	"bytes"
 	"encoding"
-	"encoding/binary"
-	"encoding/hex"
+	"fmt"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/point-c/integration/pkg/errs"
 	"github.com/point-c/wgapi"
 	"math"
-	"math/rand"
 	"net"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"text/template"
 )
 
 // NewDotPair produces a pair of configs for the server and the client.
-func NewDotPair(t errs.Testing) (DotClient, DotServer) {
-	serverPriv, serverPub := errs.Must2(wgapi.NewPrivatePublic())(t)
-	clientPriv, clientPub := errs.Must2(wgapi.NewPrivatePublic())(t)
-	shared := errs.Must(wgapi.NewPreshared())(t)
-	serverName, clientName := func(suffix string) (string, string) { return "server-" + suffix, "client-" + suffix }(hex.EncodeToString(binary.BigEndian.AppendUint32(nil, uint32(rand.Int()))))
-	serverIP, clientIP := func(c uint8) (net.IP, net.IP) { return net.IPv4(192, 168, c, 1), net.IPv4(192, 168, c, 2) }(uint8(rand.Intn(math.MaxUint8) + 1))
-	return DotClient{
-			NetworkName:  clientName,
-			Endpoint:     serverName,
-			IP:           clientIP,
-			EndpointPort: uint16(wgapi.DefaultListenPort),
+func NewDotPair(t errs.Testing, opts ...Option) (DotClient, DotServer) {
+	t.Helper()
+	server, clients := NewTopology(t, 1, opts...)
+	return clients[0], server
+}
+
+// MaxTopologyClients is the maximum number of clients that fit in the server's subnet.
+const MaxTopologyClients = math.MaxUint8 - 2
+
+// NewTopology produces a server config along with n client configs.
+// Every client has its own keys and IP in the server's subnet and is added as a peer of the server.
+// The server forwards its system traffic to the first client.
+func NewTopology(t errs.Testing, n int, opts ...Option) (DotServer, []DotClient) {
+	t.Helper()
+	o := newOptions(opts)
+	if n < 1 || n > MaxTopologyClients {
+		errs.Check(t, fmt.Errorf("topology must have between 1 and %d clients, got %d", MaxTopologyClients, n))
+	}
+	g := o.generator
+	suffix := g.Suffix()
+	subnet := o.family.subnet(g.r)
+	serverPriv, serverPub := g.PrivatePublic(t)
+	server := DotServer{
+		NetworkName: "server-" + suffix,
+		IP:          subnet(1),
+		Port:        uint16(wgapi.DefaultListenPort),
+		Private:     serverPriv,
+	}
+
+	clients := make([]DotClient, n)
+	for i := range clients {
+		clientPriv, clientPub := g.PrivatePublic(t)
+		shared := g.Preshared()
+		clients[i] = DotClient{
+			NetworkName:  clientName(suffix, i),
+			Endpoint:     server.NetworkName,
+			IP:           subnet(uint8(i + 2)),
+			EndpointPort: server.Port,
 			Private:      clientPriv,
 			Public:       serverPub,
 			Shared:       shared,
-		}, DotServer{
-			NetworkName:    serverName,
-			IP:             serverIP,
-			Port:           uint16(wgapi.DefaultListenPort),
-			Private:        serverPriv,
-			Peers:          []DotServerPeer{{NetworkName: clientName, IP: clientIP, Public: clientPub, Shared: shared}},
-			FwdNetworkName: clientName,
 		}
+		server.Peers = append(server.Peers, DotServerPeer{NetworkName: clients[i].NetworkName, IP: clients[i].IP, Public: clientPub, Shared: shared})
+	}
+	server.FwdNetworkName = clients[0].NetworkName
+	return server, clients
+}
+
+// clientName keeps the first client's name the same as a plain pair.
+func clientName(suffix string, i int) string {
+	if i == 0 {
+		return "client-" + suffix
+	}
+	return fmt.Sprintf("client-%s-%d", suffix, i)
 }
 
 // Dot is something that provides context for a template.
 type Dot interface {
+	// ApplyTemplate applies the embedded template.
 	ApplyTemplate(errs.Testing) []byte
+	// ApplyTemplateSet applies the matching template from the set.
+	ApplyTemplateSet(errs.Testing, Set) []byte
 }
 
 type (
@@ -54,6 +92,15 @@ type (
 		Port           uint16
 		Private        wgapi.PrivateKey
 		Peers          []DotServerPeer
+		// ForwardPort is the system port forwarded to port 80 of FwdNetworkName. Defaults to 80.
+		ForwardPort uint16
+		// Forwards are forwarded in addition to FwdNetworkName, each from its own system port.
+		Forwards []DotServerForward
+	}
+	// DotServerForward forwards a system port of the server to port 80 of a peer.
+	DotServerForward struct {
+		NetworkName string
+		Port        uint16
 	}
 	// DotServerPeer allows for configuring peers in the server caddyfile.
 	DotServerPeer struct {
@@ -65,7 +112,11 @@ type (
 )
 
 func (ds DotServer) ApplyTemplate(t errs.Testing) []byte {
-	return caddyfile.Format(ApplyTemplate(t, CaddyfileServer, ds))
+	return ds.ApplyTemplateSet(t, Embedded())
+}
+
+func (ds DotServer) ApplyTemplateSet(t errs.Testing, s Set) []byte {
+	return caddyfile.Format(ApplyTemplate(t, s.CaddyfileServer, ds))
 }
 
 func (ds DotServer) GetNetworkName() string {
@@ -82,29 +133,88 @@ type DotClient struct {
 	Public       wgapi.PublicKey
 	Shared       wgapi.PresharedKey
 	Directive    string
+	// HTTPPort is the system port the client serves Directive on. Defaults to 80.
+	HTTPPort uint16
 }
 
 func (dc DotClient) ApplyTemplate(t errs.Testing) []byte {
-	return caddyfile.Format(ApplyTemplate(t, CaddyfileClient, dc))
+	return dc.ApplyTemplateSet(t, Embedded())
+}
+
+func (dc DotClient) ApplyTemplateSet(t errs.Testing, s Set) []byte {
+	return caddyfile.Format(ApplyTemplate(t, s.CaddyfileClient, dc))
 }
 
 func (dc DotClient) GetNetworkName() string {
 	return dc.NetworkName
 }
 
+// DotDockerfile is a Dockerfile template config.
+// Mods are passed to xcaddy's `--with` flag. A mod in the form `module=../path` replaces the module with the local source tree at path.
+// Relative paths are resolved from the working directory of the test.
 type DotDockerfile struct {
 	Caddy string   `json:"caddy"`
 	Mods  []string `json:"mods"`
 }
 
+// SourcesDir is the folder in the build context that contains the local source trees.
+const SourcesDir = "src"
+
+// DotSource is a local source tree that is copied into the build context.
+type DotSource struct {
+	// Module is the module being replaced.
+	Module string
+	// Path is the path of the source tree on the host.
+	Path string
+	// Context is the path of the source tree in the build context.
+	Context string
+	// Container is the path of the source tree in the builder image.
+	Container string
+}
+
+// Sources returns the local source trees used by the mods.
+func (dd DotDockerfile) Sources() (s []DotSource) {
+	for i, mod := range dd.Mods {
+		module, p, ok := strings.Cut(mod, "=")
+		if !ok || !isLocalPath(p) {
+			continue
+		}
+		ctx := path.Join(SourcesDir, fmt.Sprintf("%d-%s", i, filepath.Base(p)))
+		s = append(s, DotSource{Module: module, Path: p, Context: ctx, Container: "/" + ctx})
+	}
+	return
+}
+
+// With returns the mods as they should be passed to xcaddy inside the builder image.
+func (dd DotDockerfile) With() []string {
+	with := slices.Clone(dd.Mods)
+	sources := dd.Sources()
+	for i, mod := range with {
+		module, p, _ := strings.Cut(mod, "=")
+		if j := slices.IndexFunc(sources, func(s DotSource) bool { return s.Module == module && s.Path == p }); j >= 0 {
+			with[i] = module + "=" + sources[j].Container
+		}
+	}
+	return with
+}
+
+func isLocalPath(p string) bool {
+	return p == "." || p == ".." || filepath.IsAbs(p) || strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../")
+}
+
 func (dd DotDockerfile) ApplyTemplate(t errs.Testing) []byte {
-	return ApplyTemplate(t, Dockerfile, dd)
+	return dd.ApplyTemplateSet(t, Embedded())
+}
+
+func (dd DotDockerfile) ApplyTemplateSet(t errs.Testing, s Set) []byte {
+	return ApplyTemplate(t, s.Dockerfile, dd)
 }
 
 // ApplyTemplate applies the given template to the given dot.
 func ApplyTemplate(t errs.Testing, tmpl string, dot any) []byte {
 	tm := errs.Must(template.New("").Funcs(template.FuncMap{
-		"txt": func(u encoding.TextMarshaler) string { return string(errs.Must(u.MarshalText())(t)) },
+		"txt":      func(u encoding.TextMarshaler) string { return string(errs.Must(u.MarshalText())(t)) },
+		"hostport": func(host string, port uint16) string { return net.JoinHostPort(host, strconv.Itoa(int(port))) },
 	}).Parse(tmpl))(t)
 	var buf bytes.Buffer
 	errs.Check(t, tm.Execute(&buf, dot))
@@ -0,0 +1,26 @@
+package templates
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDotDockerfile(t *testing.T) {
+	dd := DotDockerfile{Caddy: "2.7.6", Mods: []string{
+		"github.com/point-c/caddy/module",
+		"github.com/point-c/caddy=../caddy",
+		"github.com/point-c/wg=github.com/fork/wg",
+	}}
+	require.Equal(t, []DotSource{{Module: "github.com/point-c/caddy", Path: "../caddy", Context: "src/1-caddy", Container: "/src/1-caddy"}}, dd.Sources(),
+		"only replacements with a local path are sources")
+	require.Equal(t, []string{
+		"github.com/point-c/caddy/module",
+		"github.com/point-c/caddy=/src/1-caddy",
+		"github.com/point-c/wg=github.com/fork/wg",
+	}, dd.With())
+
+	b := string(dd.ApplyTemplate(t))
+	require.Contains(t, b, "COPY src/1-caddy /src/1-caddy\n")
+	require.Contains(t, b, "--with github.com/point-c/caddy=/src/1-caddy")
+	require.Contains(t, b, "--with github.com/point-c/wg=github.com/fork/wg")
+}
@@ -0,0 +1,97 @@
+package templates
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/point-c/integration/pkg/errs"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FamilyEnv selects the address family of the tunnel addresses by name, ipv4 or ipv6.
+const FamilyEnv = "POINTC_FAMILY"
+
+// Family is the address family used for the tunnel addresses.
+type Family uint8
+
+const (
+	// IPv4 addresses are taken from a random 192.168.0.0/24 subnet.
+	IPv4 Family = iota
+	// IPv6 addresses are taken from a random ULA (fd00::/8) /64 subnet.
+	IPv6
+)
+
+func (f Family) String() string {
+	switch f {
+	case IPv6:
+		return "ipv6"
+	default:
+		return "ipv4"
+	}
+}
+
+// ParseFamily parses the name of a family as returned by [Family.String].
+func ParseFamily(s string) (Family, error) {
+	for _, f := range []Family{IPv4, IPv6} {
+		if strings.EqualFold(s, f.String()) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown address family %q", s)
+}
+
+// FamilyFromEnv parses the family in [FamilyEnv]. If it is not set [IPv4] is used.
+func FamilyFromEnv(t errs.Testing) Family {
+	t.Helper()
+	if s, ok := os.LookupEnv(FamilyEnv); ok {
+		return errs.Must(ParseFamily(s))(t)
+	}
+	return IPv4
+}
+
+// subnet picks a random subnet for the family. The returned func gives the address of a host in that subnet.
+func (f Family) subnet(r *rand.Rand) func(host uint8) net.IP {
+	switch f {
+	case IPv6:
+		prefix := make(net.IP, net.IPv6len)
+		binary.BigEndian.PutUint64(prefix, 0xfd<<56|uint64(r.Int63n(1<<56)))
+		return func(host uint8) net.IP {
+			ip := slices.Clone(prefix)
+			ip[net.IPv6len-1] = host
+			return ip
+		}
+	default:
+		c := uint8(r.Intn(math.MaxUint8) + 1)
+		return func(host uint8) net.IP { return net.IPv4(192, 168, c, host) }
+	}
+}
+
+type (
+	// Option modifies how configs are generated.
+	Option  func(*options)
+	options struct {
+		family    Family
+		generator *Generator
+	}
+)
+
+// WithFamily sets the address family used for the tunnel addresses.
+func WithFamily(f Family) Option { return func(o *options) { o.family = f } }
+
+// WithGenerator sets the generator used for names, addresses, and keys. By default a generator with a random seed is used.
+func WithGenerator(g *Generator) Option { return func(o *options) { o.generator = g } }
+
+func newOptions(opts []Option) (o options) {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.generator == nil {
+		o.generator = NewGenerator(time.Now().UnixNano())
+	}
+	return
+}
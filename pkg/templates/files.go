@@ -4,7 +4,10 @@ import (
 	"bytes"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"github.com/point-c/integration/pkg/errs"
+	"io/fs"
+	"os"
 )
 
 var (
@@ -20,6 +23,74 @@ var (
 	CaddyfileServer string
 )
 
+const (
+	// TemplatesEnv is the environment variable for a directory containing template overrides.
+	TemplatesEnv = "POINTC_TEMPLATES"
+
+	DockerfileName      = "Dockerfile"
+	ClientConfigName    = "client_modules.json"
+	ServerConfigName    = "server_modules.json"
+	CaddyfileClientName = "Caddyfile.client"
+	CaddyfileServerName = "Caddyfile.server"
+)
+
+// Set is a complete set of templates used to create the server and client.
+type Set struct {
+	Dockerfile      string
+	ClientConfig    []byte
+	ServerConfig    []byte
+	CaddyfileClient string
+	CaddyfileServer string
+}
+
+// Embedded returns the templates compiled into this package.
+func Embedded() Set {
+	return Set{
+		Dockerfile:      Dockerfile,
+		ClientConfig:    ClientConfig,
+		ServerConfig:    ServerConfig,
+		CaddyfileClient: CaddyfileClient,
+		CaddyfileServer: CaddyfileServer,
+	}
+}
+
+// Load loads the templates from fsys using the same file names as the embedded templates.
+// Any files that do not exist fall back to the embedded version.
+func Load(t errs.Testing, fsys fs.FS) Set {
+	t.Helper()
+	s := Embedded()
+	for name, v := range map[string]any{
+		DockerfileName:      &s.Dockerfile,
+		ClientConfigName:    &s.ClientConfig,
+		ServerConfigName:    &s.ServerConfig,
+		CaddyfileClientName: &s.CaddyfileClient,
+		CaddyfileServerName: &s.CaddyfileServer,
+	} {
+		b, err := fs.ReadFile(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		errs.Check(t, err)
+		t.Logf("using template override %q", name)
+		switch v := v.(type) {
+		case *string:
+			*v = string(b)
+		case *[]byte:
+			*v = b
+		}
+	}
+	return s
+}
+
+// LoadDir loads the template overrides in dir. If dir is empty the embedded templates are returned.
+func LoadDir(t errs.Testing, dir string) Set {
+	t.Helper()
+	if dir == "" {
+		return Embedded()
+	}
+	return Load(t, os.DirFS(dir))
+}
+
 // DeJSON helps decode json into a type.
 func DeJSON[T any](t errs.Testing, b []byte) (v T) {
 	errs.Check(t, json.NewDecoder(bytes.NewReader(b)).Decode(&v))
@@ -0,0 +1,29 @@
+package templates
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	const dockerfile = "FROM caddy\n"
+	s := Load(t, fstest.MapFS{DockerfileName: {Data: []byte(dockerfile)}})
+	exp := Embedded()
+	exp.Dockerfile = dockerfile
+	require.Equal(t, exp, s, "templates that are not overridden must fall back to the embedded ones")
+
+	require.Equal(t, Embedded(), Load(t, fstest.MapFS{}))
+}
+
+func TestLoadDir(t *testing.T) {
+	require.Equal(t, Embedded(), LoadDir(t, ""))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ServerConfigName), []byte(`{"caddy":"2.7.5"}`), 0o644))
+	exp := Embedded()
+	exp.ServerConfig = []byte(`{"caddy":"2.7.5"}`)
+	require.Equal(t, exp, LoadDir(t, dir))
+}
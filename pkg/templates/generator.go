@@ -0,0 +1,68 @@
+package templates
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/point-c/integration/pkg/errs"
+	"github.com/point-c/wgapi"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SeedEnv is the environment variable used to rebuild a configuration from a previous seed.
+const SeedEnv = "POINTC_SEED"
+
+// Generator picks names, addresses, and keys from a seeded source.
+// Two generators with the same seed produce identical configurations.
+type Generator struct {
+	seed int64
+	r    *rand.Rand
+}
+
+// NewGenerator creates a generator from the given seed.
+func NewGenerator(seed int64) *Generator {
+	return &Generator{seed: seed, r: rand.New(rand.NewSource(seed))}
+}
+
+// NewGeneratorFromEnv creates a generator using the seed in [SeedEnv]. If it is not set a new seed is created.
+func NewGeneratorFromEnv(t errs.Testing) *Generator {
+	t.Helper()
+	if s, ok := os.LookupEnv(SeedEnv); ok {
+		return NewGenerator(errs.Must(strconv.ParseInt(s, 0, 64))(t))
+	}
+	return NewGenerator(time.Now().UnixNano())
+}
+
+// Seed is the seed of this generator.
+func (g *Generator) Seed() int64 { return g.seed }
+
+// String formats the seed so that it can be passed to [SeedEnv].
+func (g *Generator) String() string { return fmt.Sprintf("%d", g.seed) }
+
+// Suffix is a random hex string used to make names unique.
+func (g *Generator) Suffix() string {
+	return hex.EncodeToString(binary.BigEndian.AppendUint32(nil, g.r.Uint32()))
+}
+
+// PrivatePublic generates a private key and its corresponding public key.
+func (g *Generator) PrivatePublic(t errs.Testing) (wgapi.PrivateKey, wgapi.PublicKey) {
+	t.Helper()
+	k := g.key()
+	// Clamp the key as done by curve25519
+	k[0] &= 248
+	k[31] = (k[31] & 127) | 64
+	private := wgapi.PrivateKey(k)
+	public := errs.Must(private.Public())(t)
+	return private, wgapi.PublicKey(public)
+}
+
+// Preshared generates a preshared key.
+func (g *Generator) Preshared() wgapi.PresharedKey { return wgapi.PresharedKey(g.key()) }
+
+func (g *Generator) key() (k [32]byte) {
+	_, _ = g.r.Read(k[:])
+	return
+}
@@ -0,0 +1,120 @@
+package templates
+
+import (
+	"github.com/point-c/integration/pkg/errs"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// MatrixEnv is the environment variable containing a regular expression used to select which combinations of the matrix are run.
+const MatrixEnv = "POINTC_MATRIX"
+
+type (
+	// Manifest is the contents of a modules manifest. It contains the default Dockerfile config and an optional version matrix.
+	Manifest struct {
+		DotDockerfile
+		Matrix *ManifestMatrix `json:"matrix,omitempty"`
+	}
+	// ManifestMatrix lists the versions to test. Every combination of versions is tested.
+	ManifestMatrix struct {
+		// Caddy is the list of caddy versions. If empty the manifest's caddy version is used.
+		Caddy []string `json:"caddy,omitempty"`
+		// Mods maps a module to the versions that should be tested. The version replaces the module's entry in the manifest's mods, or is added if the module is not present.
+		Mods map[string][]string `json:"mods,omitempty"`
+	}
+	// ManifestEntry is a single expanded entry of a manifest.
+	ManifestEntry struct {
+		// Name describes the versions used in this entry. It is empty if the manifest has no matrix.
+		Name       string
+		Dockerfile DotDockerfile
+	}
+	// Combination is a pair of client and server manifest entries.
+	Combination struct {
+		// Name describes the versions used in this combination. It is empty if neither manifest has a matrix.
+		Name   string
+		Client ManifestEntry
+		Server ManifestEntry
+	}
+)
+
+// Expand produces an entry for every combination of versions in the manifest's matrix.
+func (m Manifest) Expand() []ManifestEntry {
+	if m.Matrix == nil {
+		return []ManifestEntry{{Dockerfile: m.DotDockerfile}}
+	}
+
+	entries := []ManifestEntry{{Dockerfile: DotDockerfile{Caddy: m.Caddy, Mods: slices.Clone(m.Mods)}}}
+	if len(m.Matrix.Caddy) > 0 {
+		entries = nil
+		for _, v := range m.Matrix.Caddy {
+			entries = append(entries, ManifestEntry{Name: "caddy-" + v, Dockerfile: DotDockerfile{Caddy: v, Mods: slices.Clone(m.Mods)}})
+		}
+	}
+
+	modules := make([]string, 0, len(m.Matrix.Mods))
+	for module := range m.Matrix.Mods {
+		modules = append(modules, module)
+	}
+	slices.Sort(modules)
+	for _, module := range modules {
+		var next []ManifestEntry
+		for _, e := range entries {
+			for _, v := range m.Matrix.Mods[module] {
+				mods := slices.Clone(e.Dockerfile.Mods)
+				i := slices.IndexFunc(mods, func(s string) bool { return s == module || strings.HasPrefix(s, module+"@") })
+				if i < 0 {
+					mods = append(mods, module+"@"+v)
+				} else {
+					mods[i] = module + "@" + v
+				}
+				next = append(next, ManifestEntry{
+					Name:       joinName(e.Name, path.Base(module)+"-"+v),
+					Dockerfile: DotDockerfile{Caddy: e.Dockerfile.Caddy, Mods: mods},
+				})
+			}
+		}
+		entries = next
+	}
+	return entries
+}
+
+// Matrix expands the client and server manifests in the set and pairs every client entry with every server entry.
+// If [MatrixEnv] is set only the combinations with a matching name are returned.
+func Matrix(t errs.Testing, s Set) (c []Combination) {
+	t.Helper()
+	var filter *regexp.Regexp
+	if f, ok := os.LookupEnv(MatrixEnv); ok {
+		filter = errs.Must(regexp.Compile(f))(t)
+	}
+	for _, client := range DeJSON[Manifest](t, s.ClientConfig).Expand() {
+		for _, server := range DeJSON[Manifest](t, s.ServerConfig).Expand() {
+			var name string
+			if client.Name != "" || server.Name != "" {
+				name = "client-" + orDefault(client.Name) + "_vs_server-" + orDefault(server.Name)
+			}
+			if filter != nil && !filter.MatchString(name) {
+				t.Logf("skipping matrix combination %q", name)
+				continue
+			}
+			c = append(c, Combination{Name: name, Client: client, Server: server})
+		}
+	}
+	return
+}
+
+func joinName(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "_" + b
+}
+
+func orDefault(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
+}
@@ -0,0 +1,90 @@
+package templates
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	const module = "github.com/point-c/caddy/module"
+	tt := []struct {
+		Name     string
+		Manifest Manifest
+		Exp      []ManifestEntry
+	}{
+		{
+			Name:     "no matrix",
+			Manifest: Manifest{DotDockerfile: DotDockerfile{Caddy: "2.7.6", Mods: []string{module}}},
+			Exp:      []ManifestEntry{{Dockerfile: DotDockerfile{Caddy: "2.7.6", Mods: []string{module}}}},
+		},
+		{
+			Name: "caddy versions",
+			Manifest: Manifest{
+				DotDockerfile: DotDockerfile{Caddy: "2.7.6", Mods: []string{module}},
+				Matrix:        &ManifestMatrix{Caddy: []string{"2.7.5", "2.7.6"}},
+			},
+			Exp: []ManifestEntry{
+				{Name: "caddy-2.7.5", Dockerfile: DotDockerfile{Caddy: "2.7.5", Mods: []string{module}}},
+				{Name: "caddy-2.7.6", Dockerfile: DotDockerfile{Caddy: "2.7.6", Mods: []string{module}}},
+			},
+		},
+		{
+			Name: "module versions",
+			Manifest: Manifest{
+				DotDockerfile: DotDockerfile{Caddy: "2.7.6", Mods: []string{module + "@v0.1.0", "github.com/other/mod"}},
+				Matrix:        &ManifestMatrix{Mods: map[string][]string{module: {"v0.1.0", "v0.1.1"}, "github.com/point-c/wg": {"v1.0.0"}}},
+			},
+			Exp: []ManifestEntry{
+				{Name: "module-v0.1.0_wg-v1.0.0", Dockerfile: DotDockerfile{Caddy: "2.7.6", Mods: []string{module + "@v0.1.0", "github.com/other/mod", "github.com/point-c/wg@v1.0.0"}}},
+				{Name: "module-v0.1.1_wg-v1.0.0", Dockerfile: DotDockerfile{Caddy: "2.7.6", Mods: []string{module + "@v0.1.1", "github.com/other/mod", "github.com/point-c/wg@v1.0.0"}}},
+			},
+		},
+		{
+			Name: "caddy and module versions",
+			Manifest: Manifest{
+				DotDockerfile: DotDockerfile{Caddy: "2.7.6", Mods: []string{module}},
+				Matrix:        &ManifestMatrix{Caddy: []string{"2.7.5", "2.7.6"}, Mods: map[string][]string{module: {"v0.1.0"}}},
+			},
+			Exp: []ManifestEntry{
+				{Name: "caddy-2.7.5_module-v0.1.0", Dockerfile: DotDockerfile{Caddy: "2.7.5", Mods: []string{module + "@v0.1.0"}}},
+				{Name: "caddy-2.7.6_module-v0.1.0", Dockerfile: DotDockerfile{Caddy: "2.7.6", Mods: []string{module + "@v0.1.0"}}},
+			},
+		},
+	}
+	for _, tt := range tt {
+		t.Run(tt.Name, func(t *testing.T) {
+			require.Equal(t, tt.Exp, tt.Manifest.Expand())
+		})
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	s := Embedded()
+	s.ClientConfig = []byte(`{"caddy":"2.7.6","matrix":{"caddy":["2.7.5","2.7.6"]}}`)
+	s.ServerConfig = []byte(`{"caddy":"2.7.6"}`)
+	tt := []struct {
+		Name   string
+		Filter string
+		Exp    []string
+	}{
+		{Name: "all", Filter: "", Exp: []string{"client-caddy-2.7.5_vs_server-default", "client-caddy-2.7.6_vs_server-default"}},
+		{Name: "filtered", Filter: `caddy-2\.7\.5`, Exp: []string{"client-caddy-2.7.5_vs_server-default"}},
+		{Name: "nothing matches", Filter: "nothing", Exp: nil},
+	}
+	for _, tt := range tt {
+		t.Run(tt.Name, func(t *testing.T) {
+			t.Setenv(MatrixEnv, tt.Filter)
+			var names []string
+			for _, c := range Matrix(t, s) {
+				names = append(names, c.Name)
+				require.Equal(t, "2.7.6", c.Server.Dockerfile.Caddy)
+			}
+			require.Equal(t, tt.Exp, names)
+		})
+	}
+
+	t.Setenv(MatrixEnv, "")
+	c := Matrix(t, Embedded())
+	require.Len(t, c, 1)
+	require.Empty(t, c[0].Name, "combinations must not be labelled without a matrix")
+}
@@ -1,6 +1,7 @@
 package caddyfile
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/caddyserver/caddy/v2/caddyconfig"
 	_ "github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
@@ -20,56 +21,55 @@ func TestCaddyfile(t *testing.T) {
 	shared := errs.Must(wgapi.NewPreshared())(t)
 	serverIP := net.IPv4(192, 168, 199, 1)
 	clientIP := net.IPv4(192, 168, 199, 2)
+	serverIP6 := net.ParseIP("fd12:3456:789a:1::1")
+	clientIP6 := net.ParseIP("fd12:3456:789a:1::2")
 	wgPort := uint16(51820)
 	clientName, serverName := "test-client", "test-server"
 
-	tt := []struct {
+	type test struct {
 		Name string
 		Dot  templates.Dot
 		Exp  []byte
-	}{
-		{
-			Name: "client",
-			Dot: templates.DotClient{
-				NetworkName:  clientName,
-				IP:           clientIP,
-				Endpoint:     "localhost",
-				EndpointPort: wgPort,
-				Private:      clientPriv,
-				Public:       serverPub,
-				Shared:       shared,
-				Directive:    "route {\nrand\n}",
-			},
-			Exp: caddyconfig.JSON(Cfg{
-				Apps: CfgApps{
-					Http: CfgAppsHttp{
-						Servers: map[string]CfgAppsHttpServers{
-							"srv0": {
-								Listen: []string{":80"},
-								Logs:   new(struct{}),
-								ListenerWrappers: []CfgAppsHttpServersLW{
-									{
-										Listeners: []CfgAppsHttpServersLWL{
-											{
-												Listener: "point-c",
-												Name:     clientName,
-												Port:     80,
-											},
+	}
+	client := func(name string, ip net.IP, endpoint, expEndpoint string) test {
+		return test{Name: name, Dot: templates.DotClient{
+			NetworkName:  clientName,
+			IP:           ip,
+			Endpoint:     endpoint,
+			EndpointPort: wgPort,
+			Private:      clientPriv,
+			Public:       serverPub,
+			Shared:       shared,
+			Directive:    "route {\nrand\n}",
+		}, Exp: caddyconfig.JSON(Cfg{
+			Apps: CfgApps{
+				Http: CfgAppsHttp{
+					Servers: map[string]CfgAppsHttpServers{
+						"srv0": {
+							Listen: []string{":80"},
+							Logs:   new(struct{}),
+							ListenerWrappers: []CfgAppsHttpServersLW{
+								{
+									Listeners: []CfgAppsHttpServersLWL{
+										{
+											Listener: "point-c",
+											Name:     clientName,
+											Port:     80,
 										},
-										Wrapper: "merge",
 									},
+									Wrapper: "merge",
 								},
-								Routes: []CfgAppsHttpServersRoutes{
-									{
-										Handle: []CfgAppsHttpServersRoutesHandle{
-											{
-												Handler: "subroute",
-												Routes: []CfgAppsHttpServersRoutes{
-													{
-														Handle: []CfgAppsHttpServersRoutesHandle{
-															{
-																Handler: "rand",
-															},
+							},
+							Routes: []CfgAppsHttpServersRoutes{
+								{
+									Handle: []CfgAppsHttpServersRoutesHandle{
+										{
+											Handler: "subroute",
+											Routes: []CfgAppsHttpServersRoutes{
+												{
+													Handle: []CfgAppsHttpServersRoutesHandle{
+														{
+															Handler: "rand",
 														},
 													},
 												},
@@ -80,80 +80,86 @@ func TestCaddyfile(t *testing.T) {
 							},
 						},
 					},
-					PointC: CfgAppsPointc{
-						Networks: []any{
-							CfgAppsPointcNetworksClient{
-								Name:      clientName,
-								Endpoint:  fmt.Sprintf("localhost:%d", wgPort),
-								IP:        clientIP.String(),
-								Preshared: string(errs.Must(shared.MarshalText())(t)),
-								Public:    string(errs.Must(serverPub.MarshalText())(t)),
-								Private:   string(errs.Must(clientPriv.MarshalText())(t)),
-								Type:      "wgclient",
-							},
+				},
+				PointC: CfgAppsPointc{
+					Networks: []any{
+						CfgAppsPointcNetworksClient{
+							Name:      clientName,
+							Endpoint:  expEndpoint,
+							IP:        ip.String(),
+							Preshared: string(errs.Must(shared.MarshalText())(t)),
+							Public:    string(errs.Must(serverPub.MarshalText())(t)),
+							Private:   string(errs.Must(clientPriv.MarshalText())(t)),
+							Type:      "wgclient",
 						},
 					},
 				},
-			}, nil),
-		},
-		{
-			Name: "server",
-			Dot: templates.DotServer{
-				NetworkName:    serverName,
-				IP:             serverIP,
-				Port:           wgPort,
-				Private:        serverPriv,
-				Peers:          []templates.DotServerPeer{{NetworkName: clientName, IP: clientIP, Public: clientPub, Shared: shared}},
-				FwdNetworkName: clientName,
 			},
-			Exp: caddyconfig.JSON(Cfg{
-				Apps: CfgApps{
-					Http: CfgAppsHttp{
-						Servers: map[string]CfgAppsHttpServers{
-							"srv0": {
-								Listen: []string{"stub://0.0.0.0:80"},
-							},
+		}, nil)}
+	}
+	server := func(name string, serverIP, clientIP net.IP) test {
+		return test{Name: name, Dot: templates.DotServer{
+			NetworkName:    serverName,
+			IP:             serverIP,
+			Port:           wgPort,
+			Private:        serverPriv,
+			Peers:          []templates.DotServerPeer{{NetworkName: clientName, IP: clientIP, Public: clientPub, Shared: shared}},
+			FwdNetworkName: clientName,
+		}, Exp: caddyconfig.JSON(Cfg{
+			Apps: CfgApps{
+				Http: CfgAppsHttp{
+					Servers: map[string]CfgAppsHttpServers{
+						"srv0": {
+							Listen: []string{"stub://0.0.0.0:80"},
 						},
 					},
-					PointC: CfgAppsPointc{
-						Networks: []any{
-							map[string]any{
-								"addr":     "0.0.0.0",
-								"hostname": "sys",
-								"type":     "system",
-							},
-							CfgAppsPointcNetworksServer{
-								Hostname:   serverName,
-								Ip:         serverIP.String(),
-								ListenPort: int(wgPort),
-								Peers: []CfgAppsPointcNetworksServerPeer{
-									{
-										Hostname:  clientName,
-										Ip:        clientIP.String(),
-										Preshared: string(errs.Must(shared.MarshalText())(t)),
-										Public:    string(errs.Must(clientPub.MarshalText())(t)),
-									},
+				},
+				PointC: CfgAppsPointc{
+					Networks: []any{
+						map[string]any{
+							"addr":     "0.0.0.0",
+							"hostname": "sys",
+							"type":     "system",
+						},
+						CfgAppsPointcNetworksServer{
+							Hostname:   serverName,
+							Ip:         serverIP.String(),
+							ListenPort: int(wgPort),
+							Peers: []CfgAppsPointcNetworksServerPeer{
+								{
+									Hostname:  clientName,
+									Ip:        clientIP.String(),
+									Preshared: string(errs.Must(shared.MarshalText())(t)),
+									Public:    string(errs.Must(clientPub.MarshalText())(t)),
 								},
-								Private: string(errs.Must(serverPriv.MarshalText())(t)),
-								Type:    "wgserver",
 							},
+							Private: string(errs.Must(serverPriv.MarshalText())(t)),
+							Type:    "wgserver",
 						},
-						NetOps: []any{
-							CfgAppsPointcNetOpsForward{
-								Forwards: []any{
-									CfgAppsPointcNetOpsForwardTCP{
-										Forward: "tcp",
-										Ports:   "80:80",
-									},
+					},
+					NetOps: []any{
+						CfgAppsPointcNetOpsForward{
+							Forwards: []any{
+								CfgAppsPointcNetOpsForwardTCP{
+									Forward: "tcp",
+									Ports:   "80:80",
 								},
-								Hosts: "sys:" + clientName,
-								Op:    "forward",
 							},
+							Hosts: "sys:" + clientName,
+							Op:    "forward",
 						},
 					},
 				},
-			}, nil),
-		},
+			},
+		}, nil)}
+	}
+
+	tt := []test{
+		client("client", clientIP, "localhost", fmt.Sprintf("localhost:%d", wgPort)),
+		server("server", serverIP, clientIP),
+		client("client ipv6", clientIP6, "::1", fmt.Sprintf("[::1]:%d", wgPort)),
+		client("client ipv6 endpoint", clientIP6, serverIP6.String(), fmt.Sprintf("[%s]:%d", serverIP6, wgPort)),
+		server("server ipv6", serverIP6, clientIP6),
 	}
 	for _, tt := range tt {
 		t.Run(tt.Name, func(t *testing.T) {
@@ -168,6 +174,61 @@ func TestCaddyfile(t *testing.T) {
 	}
 }
 
+func TestTopology(t *testing.T) {
+	for _, f := range []templates.Family{templates.IPv4, templates.IPv6} {
+		t.Run(f.String(), func(t *testing.T) { testTopology(t, f) })
+	}
+}
+
+func testTopology(t *testing.T, f templates.Family) {
+	server, clients := templates.NewTopology(t, 3, templates.WithFamily(f))
+	require.Len(t, clients, 3)
+	require.Len(t, server.Peers, len(clients))
+	require.Equal(t, clients[0].NetworkName, server.FwdNetworkName)
+
+	adapter := caddyconfig.GetAdapter("caddyfile")
+	require.NotNil(t, adapter)
+	b, warn, err := adapter.Adapt(server.ApplyTemplate(t), nil)
+	require.NoError(t, err)
+	require.Empty(t, warn)
+
+	var cfg struct {
+		Apps struct {
+			PointC struct {
+				Networks []CfgAppsPointcNetworksServer `json:"networks"`
+			} `json:"point-c"`
+		} `json:"apps"`
+	}
+	require.NoError(t, json.Unmarshal(b, &cfg))
+	require.Len(t, cfg.Apps.PointC.Networks, 2)
+	peers := cfg.Apps.PointC.Networks[1].Peers
+	require.Len(t, peers, len(clients))
+	for i, c := range clients {
+		require.Equal(t, f == templates.IPv6, c.IP.To4() == nil)
+		require.Equal(t, c.NetworkName, peers[i].Hostname)
+		require.Equal(t, c.IP.String(), peers[i].Ip)
+		require.Equal(t, string(errs.Must(c.Shared.MarshalText())(t)), peers[i].Preshared)
+		require.Equal(t, errs.Must(c.Public.MarshalText())(t), errs.Must(errs.Must(server.Private.Public())(t).MarshalText())(t))
+	}
+}
+
+func TestSeed(t *testing.T) {
+	for _, f := range []templates.Family{templates.IPv4, templates.IPv6} {
+		t.Run(f.String(), func(t *testing.T) {
+			gen := templates.NewGenerator(1234)
+			server1, clients1 := templates.NewTopology(t, 3, templates.WithFamily(f), templates.WithGenerator(gen))
+			server2, clients2 := templates.NewTopology(t, 3, templates.WithFamily(f), templates.WithGenerator(templates.NewGenerator(gen.Seed())))
+			require.Equal(t, server1.ApplyTemplate(t), server2.ApplyTemplate(t))
+			require.Len(t, clients2, len(clients1))
+			for i := range clients1 {
+				require.Equal(t, clients1[i].ApplyTemplate(t), clients2[i].ApplyTemplate(t))
+			}
+			server3, _ := templates.NewTopology(t, 3, templates.WithFamily(f), templates.WithGenerator(templates.NewGenerator(gen.Seed()+1)))
+			require.NotEqual(t, server1.ApplyTemplate(t), server3.ApplyTemplate(t))
+		})
+	}
+}
+
 type (
 	Cfg struct {
 		Apps CfgApps `json:"apps"`
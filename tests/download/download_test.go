@@ -5,8 +5,13 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	_ "github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	_ "github.com/caddyserver/caddy/v2/modules/standard"
+	_ "github.com/point-c/caddy/module"
 	"github.com/point-c/integration/pkg/docker"
 	"github.com/point-c/integration/pkg/errs"
+	"github.com/point-c/integration/pkg/local"
+	"github.com/point-c/integration/pkg/templates"
 	"github.com/point-c/simplewg"
 	"github.com/stretchr/testify/require"
 	"io"
@@ -15,38 +20,56 @@ import (
 	"time"
 )
 
+// MaxTransferTime is the longest a single download may take on a rate limited link before it is skipped.
+const MaxTransferTime = time.Minute
+
 var (
 	ServerPort uint16
 	ClientPort uint16
+	Server     docker.Container
+	Client     docker.Container
 	Ctx        *docker.MainContext
 )
 
 func TestMain(m *testing.M) {
+	local.Main()
 	t := errs.NewTestMain(m)
 	defer t.Exit()
+	for _, c := range docker.Matrix(t) {
+		run(t, c)
+	}
+}
 
-	Ctx = docker.NewMainContext(t, "route {\nrand\n}")
-	defer Ctx.Cancel()
+func run(t *errs.TestMain, c templates.Combination) {
+	Ctx = docker.NewMainContext(t, "route {\nrand\n}", docker.WithCombination(c))
+	defer Ctx.Close()
 
-	Ctx.WriteDebugZip()
-	go func() {
-		for range time.Tick(time.Second * 5) {
-			Ctx.WriteDebugZip()
+	go func(ctx *docker.MainContext) {
+		t := time.Tick(time.Second * 5)
+		for {
+			ctx.WriteDebugZip()
+			select {
+			case <-ctx.Done():
+				ctx.WriteDebugZip()
+				return
+			case <-t:
+			}
 		}
-	}()
-
-	intNet, cleanup := Ctx.GetInternalNet()
-	defer cleanup()
+	}(Ctx)
 
+	intNet, _ := Ctx.GetInternalNet()
 	networks := []string{"localhost", intNet.Name}
-	exposedPorts := []string{"80/tcp"}
-	server, cleanup := Ctx.Server.StartContainer(networks, exposedPorts, "80/tcp")
-	defer cleanup()
-	client, cleanup := Ctx.Client.StartContainer(networks, exposedPorts, "80/tcp")
-	defer cleanup()
-
-	ServerPort = uint16(errs.Must(server.MappedPort(Ctx, "80/tcp"))(t).Int())
-	ClientPort = uint16(errs.Must(client.MappedPort(Ctx, "80/tcp"))(t).Int())
+	// The server forwards a different port to every client of a shared environment
+	forward := Ctx.ForwardPort()
+	Server, _ = Ctx.Server.StartContainer(networks, []string{string(forward)}, forward)
+	Client, _ = Ctx.Client.StartContainer(networks, []string{"80/tcp"}, "80/tcp")
+
+	RefreshPorts(t)
+	// The tunnel comes up asynchronously, so wait for a request through the client to succeed
+	errs.Retry(t, func(ctx context.Context) error {
+		_, err := RandBytes(ctx, "client", ClientPort, 0, 1)
+		return err
+	}, errs.WithContext(Ctx), errs.WithTimeout(time.Second*30), errs.WithAttemptTimeout(time.Second*5))
 	require.NoError(t, Ctx.Err())
 	t.Run()
 }
@@ -74,19 +97,38 @@ func TestDownload(t *testing.T) {
 		{Gigabytes: 1},
 	}
 
-	for _, tt := range tt {
-		size, sizeStr := ParseSize(tt.Gigabytes, tt.Megabytes, tt.Kilobytes, tt.Bytes)
-		t.Run(fmt.Sprintf("requesting %s with seed of %s", sizeStr, seedStr), func(t *testing.T) {
-			var w simplewg.Wg
-			var clientR, serverR []byte
-			w.Go(func() { clientR = GetRandBytes(t, "client", Ctx, ClientPort, seed, size) })
-			w.Go(func() { serverR = GetRandBytes(t, "server", Ctx, ServerPort, seed, size) })
-			w.Wait()
-			require.Equal(t, clientR, serverR)
+	for _, p := range Ctx.LinkProfiles() {
+		t.Run("link "+p.Name, func(t *testing.T) {
+			defer Ctx.ImpairLink(p)()
+			for _, tt := range tt {
+				size, sizeStr := ParseSize(tt.Gigabytes, tt.Megabytes, tt.Kilobytes, tt.Bytes)
+				t.Run(Ctx.Labelled(fmt.Sprintf("requesting %s with seed of %s", sizeStr, seedStr)), func(t *testing.T) {
+					if d := p.TransferTime(size); d > MaxTransferTime {
+						t.Skipf("transfer would take about %s on link %q", d, p.Name)
+					}
+					// Both downloads are checked, even if one of them fails
+					c := errs.NewCollector(t)
+					var w simplewg.Wg
+					var clientR, serverR []byte
+					w.Go(func() { clientR = GetRandBytes(c, "client", Ctx, ClientPort, seed, size) })
+					w.Go(func() { serverR = GetRandBytes(c, "server", Ctx, ServerPort, seed, size) })
+					w.Wait()
+					require.Len(c, clientR, int(size), "client download size")
+					require.Len(c, serverR, int(size), "server download size")
+					c.Report()
+					require.Equal(t, clientR, serverR)
+				})
+			}
 		})
 	}
 }
 
+// RefreshPorts updates the mapped ports, which may change when a container is restarted.
+func RefreshPorts(t errs.Testing) {
+	ServerPort = uint16(errs.Must(Server.MappedPort(Ctx, Ctx.ForwardPort()))(t).Int())
+	ClientPort = uint16(errs.Must(Client.MappedPort(Ctx, "80/tcp"))(t).Int())
+}
+
 func MakeSeed() (seed int64, str string) {
 	seed = time.Now().UnixMilli()
 	str = "0x" + hex.EncodeToString(binary.BigEndian.AppendUint64(nil, uint64(seed)))
@@ -109,17 +151,40 @@ func ParseSize(gb, mb, kb, b uint) (size int64, str string) {
 }
 
 func GetRandBytes(t errs.Testing, requestedName string, ctx context.Context, port uint16, seed, size int64) []byte {
-	req := errs.Must(http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d", int(port)), nil))(t).WithContext(ctx)
+	defer func(start time.Time) {
+		t.Logf("took %s to download from %s", time.Now().Sub(start).String(), requestedName)
+	}(time.Now())
+	return errs.Must(RandBytes(ctx, requestedName, port, seed, size))(t)
+}
+
+// RandBytes downloads size random bytes generated from seed through the caddy instance on port.
+func RandBytes(ctx context.Context, requestedName string, port uint16, seed, size int64) ([]byte, error) {
+	body, err := OpenRandBytes(ctx, requestedName, port, seed, size)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// OpenRandBytes starts a download of random bytes, returning the response body once the headers are received.
+func OpenRandBytes(ctx context.Context, requestedName string, port uint16, seed, size int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://localhost:%d", int(port)), nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header = http.Header{
 		"Rand-Seed":        []string{fmt.Sprintf("%d", seed)},
 		"Rand-Size":        []string{fmt.Sprintf("%d", size)},
 		"Docker-Requested": []string{requestedName},
 	}
-
-	defer func(start time.Time) {
-		t.Logf("took %s to download from %s", time.Now().Sub(start).String(), requestedName)
-	}(time.Now())
-	resp := errs.Must(http.DefaultClient.Do(req))(t)
-	defer errs.Defer(t, resp.Body.Close)
-	return errs.Must(io.ReadAll(resp.Body))(t)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
 }
@@ -0,0 +1,103 @@
+package download
+
+import (
+	"context"
+	"github.com/point-c/integration/pkg/docker"
+	"github.com/point-c/integration/pkg/errs"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+// RecoveryTimeout is how long the tunnel may take to come back up after a fault.
+const RecoveryTimeout = time.Minute
+
+const (
+	// InFlightSize is the size of the download that is running while the fault is injected.
+	InFlightSize = 64 * 1024 * 1024
+	// InFlightStarted is how much of the download is read before the fault is injected.
+	InFlightStarted = 64 * 1024
+)
+
+func TestResilience(t *testing.T) {
+	tt := []struct {
+		Name string
+		// Restarted is the logs of the entry that is restarted by Fault, nil if nothing is restarted.
+		Restarted *docker.LogStream
+		// Server is set when Fault affects the server, which is used by other packages in a shared environment.
+		Server bool
+		Fault  func()
+	}{
+		{
+			Name:   "pause server",
+			Server: true,
+			Fault:  func() { Ctx.Server.Pause(); time.Sleep(time.Second * 5); Ctx.Server.Unpause() },
+		},
+		{
+			Name:      "kill server",
+			Server:    true,
+			Restarted: &Ctx.Server.Logs,
+			Fault:     func() { Ctx.Server.Kill(); Ctx.Server.Restart(RecoveryTimeout) },
+		},
+		{
+			Name:      "restart server",
+			Server:    true,
+			Restarted: &Ctx.Server.Logs,
+			Fault:     func() { Ctx.Server.Restart(RecoveryTimeout) },
+		},
+		{
+			Name:  "pause client",
+			Fault: func() { Ctx.Client.Pause(); time.Sleep(time.Second * 5); Ctx.Client.Unpause() },
+		},
+		{
+			Name:      "kill client",
+			Restarted: &Ctx.Client.Logs,
+			Fault:     func() { Ctx.Client.Kill(); Ctx.Client.Restart(RecoveryTimeout) },
+		},
+		{
+			Name:      "restart client",
+			Restarted: &Ctx.Client.Logs,
+			Fault:     func() { Ctx.Client.Restart(RecoveryTimeout) },
+		},
+	}
+
+	seed, _ := MakeSeed()
+	for _, tt := range tt {
+		t.Run(Ctx.Labelled(tt.Name), func(t *testing.T) {
+			if tt.Server && Ctx.Shared() {
+				t.Skip("the server is shared with other packages")
+			}
+			var since int
+			if tt.Restarted != nil {
+				since = tt.Restarted.Len()
+			}
+
+			// Start a download through the tunnel and read its first bytes, so it is in flight when the fault is injected.
+			// The rest is only read after the fault, the transfer stalls until then.
+			ctx, cancel := context.WithTimeout(Ctx, RecoveryTimeout*2)
+			defer cancel()
+			body := errs.Must(OpenRandBytes(ctx, "server", ServerPort, seed, InFlightSize))(t)
+			defer body.Close()
+			first := make([]byte, InFlightStarted)
+			_ = errs.Must(io.ReadFull(body, first))(t)
+
+			tt.Fault()
+			RefreshPorts(t)
+			if tt.Restarted != nil {
+				require.NotEmpty(t, tt.Restarted.Filter(since, docker.TunnelUp), "tunnel did not come back up")
+			}
+
+			recovered := errs.Eventually(t, func(ctx context.Context) ([]byte, error) {
+				return RandBytes(ctx, "server", ServerPort, seed, InFlightSize)
+			}, errs.WithContext(Ctx), errs.WithTimeout(RecoveryTimeout), errs.WithAttemptTimeout(time.Second*10), errs.WithBackoff(time.Second, time.Second*5))
+
+			require.Equal(t, recovered[:InFlightStarted], first, "in-flight request returned corrupt data before the fault")
+			if rest, err := io.ReadAll(body); err != nil {
+				t.Logf("in-flight request failed after the fault: %s", err)
+			} else {
+				require.Equal(t, recovered, append(first, rest...), "in-flight request returned corrupt data")
+			}
+		})
+	}
+}
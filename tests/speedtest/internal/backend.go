@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// chunkSize is the size of a single chunk of garbage data.
+	chunkSize = 1024 * 1024
+	// maxChunks is the maximum number of chunks that can be requested.
+	maxChunks = 1024
+)
+
+// Backend is a minimal librespeed backend. It is used in place of the speedtest container when running without docker.
+func Backend() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/garbage.php", garbage)
+	mux.HandleFunc("/empty.php", empty)
+	mux.HandleFunc("/getIP.php", getIP)
+	return mux
+}
+
+// garbage writes ckSize chunks of random data.
+func garbage(w http.ResponseWriter, r *http.Request) {
+	chunks, err := strconv.Atoi(r.URL.Query().Get("ckSize"))
+	if err != nil || chunks <= 0 {
+		chunks = 4
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	chunk := make([]byte, chunkSize)
+	_, _ = rand.Read(chunk)
+	for i := 0; i < min(chunks, maxChunks); i++ {
+		if _, err := w.Write(chunk); err != nil {
+			return
+		}
+	}
+}
+
+// empty discards the request body.
+func empty(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(io.Discard, r.Body)
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.Header().Set("Connection", "keep-alive")
+}
+
+// getIP returns the address of the requester.
+func getIP(w http.ResponseWriter, r *http.Request) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		ProcessedString string `json:"processedString"`
+		RawISPInfo      string `json:"rawIspInfo"`
+	}{ProcessedString: ip})
+}
@@ -2,52 +2,33 @@ package internal
 
 import (
 	"bytes"
-	_ "embed"
+	"embed"
+	"fmt"
 	"github.com/point-c/integration/pkg/archive"
 	"github.com/point-c/integration/pkg/errs"
 	"io"
+	"io/fs"
 	"sync"
-	"time"
 )
 
 var (
-	//go:embed speedtest-srv/Dockerfile
-	Dockerfile []byte
-	//go:embed speedtest-srv/main.go
-	Main []byte
-	//go:embed speedtest-srv/speedtest-srv/model.go
-	Models  []byte
-	ctx     []byte
-	ctxOnce sync.Once
+	// Sources are the sources of the speedtest client image.
+	//go:embed speedtest-srv
+	Sources embed.FS
+	// ctx caches the build contexts by the Go syntax of their archiver, so archivers do not need to be comparable.
+	ctx  = map[string][]byte{}
+	ctxL sync.Mutex
 )
 
-func Context(t errs.Testing) io.Reader {
-	ctxOnce.Do(func() {
+// Context returns the build context of the speedtest client, archived with a.
+func Context(t errs.Testing, a archive.Archiver) io.Reader {
+	ctxL.Lock()
+	defer ctxL.Unlock()
+	key := fmt.Sprintf("%#v", a)
+	if _, ok := ctx[key]; !ok {
 		var buf bytes.Buffer
-		archive.Archive[archive.Tar](t, &buf,
-			archive.Entry[[]byte]{
-				Name:    "Dockerfile",
-				Time:    time.Now(),
-				Content: Dockerfile,
-			},
-			archive.Entry[[]byte]{
-				Name:    "main.go",
-				Time:    time.Now(),
-				Content: Main,
-			},
-			archive.Entry[[]archive.FileHeader]{
-				Name: "speedtest-srv",
-				Time: time.Now(),
-				Content: []archive.FileHeader{
-					archive.Entry[[]byte]{
-						Name:    "model.go",
-						Time:    time.Now(),
-						Content: Models,
-					},
-				},
-			},
-		)
-		ctx = buf.Bytes()
-	})
-	return bytes.NewReader(ctx)
+		archive.ArchiveWith(t, a, &buf, archive.FS(t, errs.Must(fs.Sub(Sources, "speedtest-srv"))(t))...)
+		ctx[key] = buf.Bytes()
+	}
+	return bytes.NewReader(ctx[key])
 }
@@ -4,14 +4,21 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	_ "github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	_ "github.com/caddyserver/caddy/v2/modules/standard"
+	_ "github.com/point-c/caddy/module"
+	"github.com/point-c/integration/pkg/archive"
 	"github.com/point-c/integration/pkg/docker"
 	"github.com/point-c/integration/pkg/errs"
+	"github.com/point-c/integration/pkg/local"
+	"github.com/point-c/integration/pkg/templates"
 	"github.com/point-c/integration/tests/speedtest/internal"
 	speedtest_srv "github.com/point-c/integration/tests/speedtest/internal/speedtest-srv/speedtest-srv"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"io"
 	"net"
+	"net/http"
 	"net/rpc"
 	"os"
 	"strings"
@@ -30,54 +37,48 @@ const (
 )
 
 var (
-	Ctx                    *docker.MainContext
-	SpeedtestClientRequest func(int) testcontainers.ContainerRequest
-	Results                = make(chan Report)
+	Ctx *docker.MainContext
+	// SpeedtestClient starts a speedtest client that can reach the caddy instance with the given id.
+	// It returns the address of the client's rpc server and the address of the caddy instance as seen by the client.
+	SpeedtestClient func(t *testing.T, id int) (addr string, target speedtest_srv.ServerInfo, cleanup func())
+	Results         = make(chan Report)
 )
 
 func TestMain(m *testing.M) {
+	local.Main()
 	t := errs.NewTestMain(m)
 	defer t.Exit()
+	for _, c := range docker.Matrix(t) {
+		if os.Getenv(docker.BackendEnv) == docker.BackendLocal {
+			runLocal(t, c)
+		} else {
+			run(t, c)
+		}
+	}
+}
 
-	Ctx = docker.NewMainContext(t, fmt.Sprintf("reverse_proxy %s:80", SpeedTestServerName))
-	defer Ctx.Cancel()
+func run(t *errs.TestMain, c templates.Combination) {
+	Ctx = docker.NewMainContext(t, fmt.Sprintf("reverse_proxy %s:80", SpeedTestServerName), docker.WithCombination(c))
+	defer Ctx.Close()
 	defer collectAndDefer(t)()
+	writeDebugZips(Ctx)
 
-	go func() {
-		t := time.Tick(time.Second * 5)
-		for {
-			Ctx.WriteDebugZip()
-			select {
-			case <-Ctx.Done():
-				Ctx.WriteDebugZip()
-				return
-			case <-t:
-			}
-		}
-	}()
+	// Everything created through Ctx is removed by Ctx.Close, newest first
+	intNet, _ := Ctx.GetInternalNet()
+	speedtestServerNet, _ := Ctx.GetInternalNet()
+	speedtestCliClientNet, _ := Ctx.GetInternalNet()
+	speedtestCliServerNet, _ := Ctx.GetInternalNet()
 
-	intNet, cleanup := Ctx.GetInternalNet()
-	defer cleanup()
-	speedtestServerNet, cleanup := Ctx.GetInternalNet()
-	defer cleanup()
-	speedtestCliClientNet, cleanup := Ctx.GetInternalNet()
-	defer cleanup()
-	speedtestCliServerNet, cleanup := Ctx.GetInternalNet()
-	defer cleanup()
-
-	_, cleanup = Ctx.GetContainer(testcontainers.ContainerRequest{
+	Ctx.GetContainer(testcontainers.ContainerRequest{
 		Image:    "adolfintel/speedtest",
 		Hostname: SpeedTestServerName,
 		Networks: []string{speedtestServerNet.Name},
 		Env:      map[string]string{"MODE": "backend"},
 	})
-	defer cleanup()
-	_, cleanup = Ctx.Server.StartContainer([]string{intNet.Name, speedtestCliServerNet.Name}, nil)
-	defer cleanup()
-	_, cleanup = Ctx.Client.StartContainer([]string{intNet.Name, speedtestServerNet.Name, speedtestCliClientNet.Name}, nil)
-	defer cleanup()
+	Ctx.Server.StartContainer([]string{intNet.Name, speedtestCliServerNet.Name}, nil)
+	Ctx.Client.StartContainer([]string{intNet.Name, speedtestServerNet.Name, speedtestCliClientNet.Name}, nil)
 
-	SpeedtestClientRequest = SpeedtestClientRequestFn(t, speedtestCliServerNet.Name, speedtestCliClientNet.Name)
+	SpeedtestClient = SpeedtestClientFn(t, speedtestCliServerNet.Name, speedtestCliClientNet.Name)
 	select {
 	case <-Ctx.Done():
 	default:
@@ -85,34 +86,87 @@ func TestMain(m *testing.M) {
 	}
 }
 
+// runLocal runs the tests without docker. The speedtest backend and client run in the test process.
+func runLocal(t *errs.TestMain, c templates.Combination) {
+	backend := errs.Must(net.Listen("tcp", "127.0.0.1:0"))(t)
+	defer errs.Defer(t, backend.Close)
+	go func() { _ = http.Serve(backend, internal.Backend()) }()
+
+	Ctx = docker.NewMainContext(t, fmt.Sprintf("reverse_proxy %s", backend.Addr()), docker.WithCombination(c), docker.WithLocal(true))
+	defer Ctx.Close()
+	defer collectAndDefer(t)()
+	writeDebugZips(Ctx)
+
+	server, _ := Ctx.Server.StartContainer(nil, nil, "80/tcp")
+	client, _ := Ctx.Client.StartContainer(nil, nil, "80/tcp")
+
+	srv := rpc.NewServer()
+	errs.Check(t, srv.Register(new(speedtest_srv.SpeedTest)))
+	ln := errs.Must(net.Listen("tcp", "127.0.0.1:0"))(t)
+	defer errs.Defer(t, ln.Close)
+	go srv.Accept(ln)
+
+	SpeedtestClient = func(t *testing.T, id int) (string, speedtest_srv.ServerInfo, func()) {
+		var c docker.Container
+		switch id {
+		case ServerID:
+			c = server
+		case ClientID:
+			c = client
+		default:
+			errs.Check(t, fmt.Errorf("invalid id %d", id))
+		}
+		port := errs.Must(c.MappedPort(Ctx, "80/tcp"))(t)
+		return ln.Addr().String(), speedtest_srv.ServerInfo{Hostname: "127.0.0.1", Port: uint16(port.Int())}, func() {}
+	}
+	select {
+	case <-Ctx.Done():
+	default:
+		t.Run()
+	}
+}
+
+func writeDebugZips(ctx *docker.MainContext) {
+	go func() {
+		t := time.Tick(time.Second * 5)
+		for {
+			ctx.WriteDebugZip()
+			select {
+			case <-ctx.Done():
+				ctx.WriteDebugZip()
+				return
+			case <-t:
+			}
+		}
+	}()
+}
+
 func TestServer(t *testing.T) {
-	speedtest(t, ServerID, "VPN", 3)
+	for _, p := range Ctx.LinkProfiles() {
+		t.Run("link "+p.Name, func(t *testing.T) {
+			defer Ctx.ImpairLink(p)()
+			speedtest(t, ServerID, "VPN "+p.Name, 3)
+		})
+	}
 }
 
 func TestClient(t *testing.T) {
-	speedtest(t, ClientID, "Caddy", 3)
+	for _, p := range Ctx.LinkProfiles() {
+		t.Run("link "+p.Name, func(t *testing.T) {
+			defer Ctx.ImpairLink(p)()
+			speedtest(t, ClientID, "Caddy "+p.Name, 3)
+		})
+	}
 }
 
 func speedtest(t *testing.T, id int, name string, count uint) {
-	c, cleanup := Ctx.GetContainer(SpeedtestClientRequest(id))
+	addr, serverInfo, cleanup := SpeedtestClient(t, id)
 	defer cleanup()
+	serverInfo.Name = name
 
-	var hostname string
-	switch id {
-	case ServerID:
-		hostname = Ctx.Server.Config.NetworkName
-	case ClientID:
-		hostname = Ctx.Client.Config.NetworkName
-	}
-
-	ctx, cancel := context.WithCancel(Ctx)
-	defer cancel()
-
-	serverInfo := speedtest_srv.ServerInfo{Name: name, Hostname: hostname, Port: 80}
 	for i := uint(0); i < count; i++ {
-		t.Run(fmt.Sprintf("speedtesting: %s %d", name, i+1), func(t *testing.T) {
-			rep := Report{Timestamp: time.Now(), Name: fmt.Sprintf("%s-%d", name, i+1)}
-			addr := fmt.Sprintf("localhost:%d", errs.Must(c.MappedPort(ctx, "8080/tcp"))(t).Int())
+		t.Run(Ctx.Labelled(fmt.Sprintf("speedtesting: %s %d", name, i+1)), func(t *testing.T) {
+			rep := Report{Timestamp: time.Now(), Name: Ctx.Labelled(fmt.Sprintf("%s-%d", name, i+1))}
 			t.Logf("dialing speedtest server: %s", addr)
 			conn := errs.Must(new(net.Dialer).DialContext(Ctx, "tcp", addr))(t)
 			client := rpc.NewClient(conn)
@@ -174,6 +228,27 @@ func writeOutput(t errs.Testing, r []Report, w io.Writer) {
 }
 func fmtFloat(f float64) string { return fmt.Sprintf("%.2f", f) }
 
+// SpeedtestClientFn starts speedtest clients in docker containers.
+func SpeedtestClientFn(t errs.Testing, serverNet, clientNet string) func(*testing.T, int) (string, speedtest_srv.ServerInfo, func()) {
+	request := SpeedtestClientRequestFn(t, serverNet, clientNet)
+	return func(t *testing.T, id int) (string, speedtest_srv.ServerInfo, func()) {
+		c, cleanup := Ctx.GetContainer(request(id))
+		var hostname string
+		switch id {
+		case ServerID:
+			hostname = Ctx.Server.Config.NetworkName
+		case ClientID:
+			hostname = Ctx.Client.Config.NetworkName
+		}
+		addr := fmt.Sprintf("localhost:%d", errs.Must(c.MappedPort(Ctx, "8080/tcp"))(t).Int())
+		port := 80
+		if id == ServerID {
+			port = Ctx.ForwardPort().Int()
+		}
+		return addr, speedtest_srv.ServerInfo{Hostname: hostname, Port: uint16(port)}, cleanup
+	}
+}
+
 func SpeedtestClientRequestFn(t errs.Testing, serverNet, clientNet string) func(server int) testcontainers.ContainerRequest {
 	return func(server int) testcontainers.ContainerRequest {
 		var netName string
@@ -190,7 +265,7 @@ func SpeedtestClientRequestFn(t errs.Testing, serverNet, clientNet string) func(
 			WaitingFor: wait.ForLog(".*server started.*").AsRegexp(),
 			FromDockerfile: testcontainers.FromDockerfile{
 				Tag:            "speedtest-cli",
-				ContextArchive: internal.Context(t),
+				ContextArchive: internal.Context(t, archive.TarGz{}),
 				PrintBuildLog:  true,
 			},
 			Networks:     []string{"localhost", netName},